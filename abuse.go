@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const bannedMessage = "Sorry, but you can't ask any more questions right now."
+
+// Abuse events add to the abuse score of a uuid or ipAddrHash. Once a score within abuseWindow reaches
+// abuseBanThreshold, the key is banned for abuseBanDuration.
+var abusePoints = map[string]int{
+	"rate-limit": 1, // a question while rate-limited
+	"spam":       2, // the visitor's last question again, right away (see isRepeatedQuestion)
+	"injection":  3, // a prompt injection attempt
+}
+
+var (
+	abuseWindow       = time.Hour
+	abuseBanThreshold = 10
+	abuseBanDuration  = 24 * time.Hour
+	// A question is only spam if it repeats the visitor's last question within this long
+	spamInterval = time.Minute
+)
+
+// isRepeatedQuestion tells whether a question repeats the visitor's last one (see lastQuestion), ignoring case and
+// surrounding whitespace. Short questions, like "yes" or "thanks", are expected to come up more than once.
+func isRepeatedQuestion(last, question string) bool {
+	return len(strings.Fields(question)) >= 3 && strings.EqualFold(strings.TrimSpace(last), strings.TrimSpace(question))
+}
+
+// reportAbuse adds an abuse event to the scores of the keys, and bans the keys whose score is too high. It returns
+// whether any key got banned.
+func reportAbuse(ctx context.Context, conn *pgx.Conn, event string, keys ...string) bool {
+	banned := false
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		score := addAbuseEvent(ctx, conn, key, event, abusePoints[event], abuseWindow)
+		if score >= abuseBanThreshold {
+			addBan(ctx, conn, key, fmt.Sprintf("automatic: abuse score %d (last event: %s)", score, event), "auto",
+				time.Now().Add(abuseBanDuration))
+			banned = true
+		}
+	}
+	return banned
+}
+
+func printBans(bans []ban) {
+	fmt.Printf("%-64s  %-25s  %-25s  %-5s  %s\n", "KEY", "BANNED", "EXPIRES", "BY", "REASON")
+	for _, b := range bans {
+		expires := "never"
+		if b.expires != nil {
+			expires = b.expires.Format(time.RFC3339)
+		}
+		fmt.Printf("%-64s  %-25s  %-25s  %-5s  %s\n", b.key, b.timestamp.Format(time.RFC3339), expires, b.createdBy,
+			b.reason)
+	}
+	fmt.Printf("%d bans\n", len(bans))
+}
+
+// adminBan bans a uuid, ipAddrHash or subnet hash: ./portfolio-chatbot admin ban [-for 24h] {key} {reason}
+func adminBan(args []string, ctx context.Context, conn *pgx.Conn) {
+	flags := flag.NewFlagSet("admin ban", flag.ExitOnError)
+	duration := flags.Duration("for", 0, "how long the ban lasts (default: forever)")
+	fail(flags.Parse(args))
+	if flags.NArg() < 2 {
+		fmt.Println(adminUsage)
+		os.Exit(2)
+	}
+
+	key, reason := flags.Arg(0), strings.Join(flags.Args()[1:], " ")
+	var expires time.Time
+	if *duration > 0 {
+		expires = time.Now().Add(*duration)
+	}
+	addBan(ctx, conn, key, reason, "admin", expires)
+	logAdminAction(ctx, conn, "ban", key+": "+reason)
+	if *duration > 0 {
+		fmt.Printf("Banned %s until %s\n", key, expires.Format(time.RFC1123))
+	} else {
+		fmt.Printf("Banned %s\n", key)
+	}
+}
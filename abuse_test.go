@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestIsRepeatedQuestion(t *testing.T) {
+
+	testAssert(t, isRepeatedQuestion("Where is Kris?", "  where is kris?"))
+	testAssert(t, !isRepeatedQuestion("Where is Kris?", "Where does Kris work?"))
+	// Short answers to the chatbot's own questions aren't spam
+	testAssert(t, !isRepeatedQuestion("yes", "yes") && !isRepeatedQuestion("Thanks!", "thanks!"))
+}
+
+func TestAbuseBan(t *testing.T) {
+
+	ctx := context.Background()
+	conn := setupDB(ctx)
+	defer conn.Close(ctx)
+
+	settings := getSettings()
+	uuid_ := uuid.NewString()
+	ipAddrHash := uuid.NewString()
+	debugMode := __debugModeOff
+
+	// Spamming the same question gets the visitor banned, but not everyone else on the same ipAddrHash
+	for i := 0; i < abuseBanThreshold; i++ {
+		if answerQuestion(uuid_, ipAddrHash, "Where is Kris?", "", settings, ctx, conn, nil, debugMode).Text == bannedMessage {
+			break
+		}
+	}
+	b, banned := activeBan(ctx, conn, uuid_)
+	testAssert(t, banned && b.createdBy == "auto" && b.expires != nil)
+	_, banned = activeBan(ctx, conn, ipAddrHash)
+	testAssert(t, !banned)
+	testAssert(t, answerQuestion(uuid_, ipAddrHash, "Hi", "", settings, ctx, conn, nil, debugMode).Text ==
+		bannedMessage)
+
+	testAssert(t, liftBan(ctx, conn, uuid_) == 1)
+	_, banned = activeBan(ctx, conn, uuid_, ipAddrHash)
+	testAssert(t, !banned)
+
+	addBan(ctx, conn, ipAddrHash, "test", "admin", time.Time{})
+	b, banned = activeBan(ctx, conn, uuid_, ipAddrHash)
+	testAssert(t, banned && b.expires == nil && b.reason == "test")
+	liftBan(ctx, conn, ipAddrHash)
+
+	// Bans on an ipAddrHash outlive the day's salt, since they're stored under the address's ban key
+	now := time.Now()
+	banKey, today, tomorrow := uuid.NewString(), uuid.NewString(), uuid.NewString()
+	linkBanKeys(ctx, conn, now, map[string]string{today: banKey})
+	addBan(ctx, conn, today, "test", "admin", now.Add(7*24*time.Hour))
+	unwrap(conn.Exec(ctx, "DELETE FROM ip_links WHERE hash = $1", today)) // as ipSalt does the next day
+	linkBanKeys(ctx, conn, now, map[string]string{tomorrow: banKey})
+	_, banned = activeBan(ctx, conn, tomorrow)
+	testAssert(t, banned)
+	testAssert(t, liftBan(ctx, conn, tomorrow) == 1)
+}
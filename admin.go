@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const adminUsage = `Usage: ./portfolio-chatbot admin {action} ...
+  enable [reason]                      turn the chatbot on
+  disable {reason}                     turn the chatbot off
+  settings                             show the effective settings (settings + local-settings)
+  sessions [-since 30m]                list recently active visitors
+  transcript {uuid}                    show a visitor's conversation
+  reset-ratelimit {uuid|ipAddrHash}    let a visitor ask questions again right away
+  purge {uuid}                         delete everything about a visitor
+  bans [-all]                          list the bans in effect (or all of them)
+  ban [-for 24h] {key} {reason}        ban a uuid, ipAddrHash or subnet hash
+  unban {key}                          lift the bans of a uuid, ipAddrHash or subnet hash
+  stats [-since 24h]                   show usage statistics`
+
+// settingsFileKeys returns the names of the settings that a settings file sets
+func settingsFileKeys(fileName string) map[string]bool {
+	keys := make(map[string]bool)
+	if !fileExists(fileName) {
+		return keys
+	}
+	for _, line := range strings.Split(readFile(fileName), "\n") {
+		if key, _, ok := strings.Cut(line, "="); ok {
+			keys[key] = true
+		}
+	}
+	return keys
+}
+
+// writeSetting changes a setting in whichever settings file decides its effective value (see getSettings)
+func writeSetting(setting, val string) string {
+	fileName := "./settings"
+	if settingsFileKeys("./local-settings")[setting] {
+		fileName = "./local-settings"
+	}
+	lines := strings.Split(readFile(fileName), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, setting+"=") {
+			lines[i] = setting + "=" + val
+		}
+	}
+	fail(os.WriteFile(fileName, []byte(strings.Join(lines, "\n")), 0644))
+	return fileName
+}
+
+func adminSetEnabled(enabled bool, reason string, ctx context.Context, conn *pgx.Conn) {
+	action := "enable"
+	if !enabled {
+		action = "disable"
+	}
+	fileName := writeSetting("chatbot-enabled", fmt.Sprint(enabled))
+	getSettings() // fail loudly if the edit broke the settings
+	logAdminAction(ctx, conn, action, reason)
+	fmt.Printf("chatbot-enabled=%t (in %s)\n", enabled, fileName)
+}
+
+func adminSettings(settings settings, ctx context.Context, conn *pgx.Conn) {
+	local := settingsFileKeys("./local-settings")
+	for _, entry := range settings.entries() {
+		if local[entry[0]] {
+			fmt.Printf("%s=%s (local-settings)\n", entry[0], entry[1])
+		} else {
+			fmt.Printf("%s=%s\n", entry[0], entry[1])
+		}
+	}
+	if action, reason, timestamp, ok := lastAdminAction(ctx, conn, "enable", "disable"); ok {
+		fmt.Printf("\nLast %sd %s: %s\n", action, timestamp.Format(time.RFC1123), reason)
+	}
+}
+
+func adminSessions(args []string, ctx context.Context, conn *pgx.Conn) {
+	flags := flag.NewFlagSet("admin sessions", flag.ExitOnError)
+	since := flags.Duration("since", 30*time.Minute, "only list visitors active this recently")
+	fail(flags.Parse(args))
+
+	sessions := recentSessions(ctx, conn, time.Now().Add(-*since))
+	fmt.Printf("%-36s  %-25s  %s\n", "UUID", "LAST ACTIVITY", "QUESTIONS")
+	for _, s := range sessions {
+		fmt.Printf("%-36s  %-25s  %d\n", s.uuid, s.lastActivity.Format(time.RFC3339), s.questions)
+	}
+	fmt.Printf("%d active sessions\n", len(sessions))
+}
+
+func adminTranscript(uuid string, ctx context.Context, conn *pgx.Conn) {
+	messages := sessionMessages(ctx, conn, uuid)
+	for _, m := range messages {
+		fmt.Printf("[%s] %s\n", m.timestamp.Format(time.RFC3339), m.text)
+	}
+	if len(messages) == 0 {
+		fmt.Printf("No messages for %s\n", uuid)
+	}
+}
+
+func adminStats(args []string, ctx context.Context, conn *pgx.Conn) {
+	flags := flag.NewFlagSet("admin stats", flag.ExitOnError)
+	since := flags.Duration("since", 24*time.Hour, "only count activity this recent")
+	fail(flags.Parse(args))
+
+	u := usageSince(ctx, conn, time.Now().Add(-*since))
+	fmt.Printf("Since %s:\n", time.Now().Add(-*since).Format(time.RFC1123))
+	fmt.Printf("  %-18s %d\n", "sessions", u.sessions)
+	fmt.Printf("  %-18s %d\n", "questions", u.questions)
+	fmt.Printf("  %-18s %d\n", "answers", u.answers)
+	fmt.Printf("  %-18s %d\n", "prompt tokens", u.promptTokens)
+	fmt.Printf("  %-18s %d\n", "completion tokens", u.completionTokens)
+	fmt.Printf("  %-18s %d\n", "rate-limit hits", u.rateLimitHits)
+	fmt.Printf("  %-18s %d\n", "leads", u.leads)
+	fmt.Printf("  %-18s %d\n", "cache hits", u.cacheHits)
+	fmt.Printf("  %-18s %d\n", "cache misses", u.cacheMisses)
+	fmt.Printf("  %-18s %d\n", "fit analyses", u.fitAnalyses)
+	fmt.Printf("  %-18s %d\n", "fit prompt tokens", u.fitPromptTokens)
+	fmt.Printf("  %-18s %d\n", "fit compl. tokens", u.fitCompletionTokens)
+}
+
+func adminCommand(args []string, settings settings, ctx context.Context, conn *pgx.Conn) {
+	if len(args) == 0 {
+		fmt.Println(adminUsage)
+		os.Exit(2)
+	}
+	action, args := args[0], args[1:]
+	reason := strings.Join(args, " ")
+
+	switch {
+	case action == "enable":
+		adminSetEnabled(true, reason, ctx, conn)
+	case action == "disable" && reason != "":
+		adminSetEnabled(false, reason, ctx, conn)
+	case action == "settings" && len(args) == 0:
+		adminSettings(settings, ctx, conn)
+	case action == "sessions":
+		adminSessions(args, ctx, conn)
+	case action == "transcript" && len(args) == 1:
+		adminTranscript(args[0], ctx, conn)
+	case action == "reset-ratelimit" && len(args) == 1:
+		if resetRateLimit(ctx, conn, args[0]) {
+			logAdminAction(ctx, conn, action, args[0])
+			fmt.Printf("Reset the rate limit of %s\n", args[0])
+		} else {
+			fmt.Printf("%s is not rate-limited\n", args[0])
+		}
+	case action == "purge" && len(args) == 1:
+		// Not in the admin log: the erasure's audit record deliberately doesn't keep the uuid
+		for table, count := range findDataSubject(ctx, conn, "uuid", args[0]).erase(ctx, conn, "admin") {
+			fmt.Printf("Deleted %d rows from %s\n", count, table)
+		}
+	case action == "bans":
+		flags := flag.NewFlagSet("admin bans", flag.ExitOnError)
+		all := flags.Bool("all", false, "also list expired and lifted bans")
+		fail(flags.Parse(args))
+		printBans(listBans(ctx, conn, *all))
+	case action == "ban":
+		adminBan(args, ctx, conn)
+	case action == "unban" && len(args) == 1:
+		if liftBan(ctx, conn, args[0]) > 0 {
+			logAdminAction(ctx, conn, action, args[0])
+			fmt.Printf("Lifted the bans of %s\n", args[0])
+		} else {
+			fmt.Printf("%s is not banned\n", args[0])
+		}
+	case action == "stats":
+		adminStats(args, ctx, conn)
+	default:
+		fmt.Println(adminUsage)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Without this file, answers aren't cached
+const cacheFile = "cache.yaml"
+
+type cacheConfig struct {
+	TTL time.Duration `yaml:"ttl"`
+}
+
+// loadCacheConfig reads cache.yaml, e.g.:
+//
+//	ttl: 24h
+func loadCacheConfig() *cacheConfig {
+	if !fileExists(cacheFile) {
+		return nil
+	}
+	var config cacheConfig
+	decoder := yaml.NewDecoder(strings.NewReader(readFile(cacheFile)))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil {
+		log.Fatalf("%s: %v", cacheFile, err)
+	}
+	if config.TTL <= 0 {
+		log.Fatalf("%s: ttl should be positive", cacheFile)
+	}
+	return &config
+}
+
+// normalizeQuestion makes questions that only differ in case, spacing or final punctuation the same
+func normalizeQuestion(question string) string {
+	return strings.TrimRight(strings.Join(strings.Fields(strings.ToLower(question)), " "), "?!. ")
+}
+
+// answerCacheKey identifies the answer to a context-free question (one without chat history). Besides the question,
+// it covers everything else that goes into the answer: the knowledge (the prompt without a question, so the resume,
+// the facts and the prompt version), the model and its token limit. Changing any of them makes for new keys, which is
+// what invalidates the cache.
+func answerCacheKey(arm arm, knowledge, question string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%d\x00%s", knowledge, arm.Model, arm.MaxTokens,
+		normalizeQuestion(question))))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestAnswerCacheKey(t *testing.T) {
+
+	control := arm{Name: "control", PromptVersion: "v1", Model: "gpt-4", MaxTokens: 200}
+	knowledge := "Kris went to Grand Circus Java Bootcamp."
+	key := answerCacheKey(control, knowledge, "Where did Kris go to school?")
+
+	testAssert(t, answerCacheKey(control, knowledge, "  where did KRIS go to school ") == key)
+	testAssert(t, answerCacheKey(control, knowledge, "Where did Kris work?") != key)
+
+	cheaper := control
+	cheaper.Model = "gpt-3.5-turbo"
+	testAssert(t, answerCacheKey(cheaper, knowledge, "Where did Kris go to school?") != key)
+
+	// New facts make for a new key, which is what invalidates the cache
+	knowledge += " Kris now lives in Ann Arbor."
+	testAssert(t, answerCacheKey(control, knowledge, "Where did Kris go to school?") != key)
+}
+
+func TestAnswerCache(t *testing.T) {
+
+	ctx := context.Background()
+	conn := setupDB(ctx)
+	defer conn.Close(ctx)
+
+	key := uuid.NewString()
+	before := usageSince(ctx, conn, time.Now().Add(-time.Minute))
+
+	_, hit := cachedAnswer(ctx, conn, key, time.Hour)
+	testAssert(t, !hit)
+	cacheAnswer(ctx, conn, key, "Kris went to Grand Circus.")
+	answer, hit := cachedAnswer(ctx, conn, key, time.Hour)
+	testAssert(t, hit && answer == "Kris went to Grand Circus.")
+
+	after := usageSince(ctx, conn, time.Now().Add(-time.Minute))
+	testAssert(t, after.cacheHits-before.cacheHits == 1 && after.cacheMisses-before.cacheMisses == 1)
+
+	// Expired answers are misses
+	time.Sleep(1100 * time.Millisecond)
+	_, hit = cachedAnswer(ctx, conn, key, time.Second)
+	testAssert(t, !hit)
+
+	// So are answers sealed with a key that has since been rotated, which are deleted
+	t.Setenv(keyEnvVar, base64.StdEncoding.EncodeToString(randomSecret()))
+	cacheAnswer(ctx, conn, key, "Kris went to Grand Circus.")
+	t.Setenv(keyEnvVar, base64.StdEncoding.EncodeToString(randomSecret()))
+	before = usageSince(ctx, conn, time.Now().Add(-time.Minute))
+	_, hit = cachedAnswer(ctx, conn, key, time.Hour)
+	testAssert(t, !hit)
+	after = usageSince(ctx, conn, time.Now().Add(-time.Minute))
+	testAssert(t, after.cacheHits == before.cacheHits && after.cacheMisses-before.cacheMisses == 1)
+	var cached int
+	fail(conn.QueryRow(ctx, "SELECT count(*) FROM answer_cache WHERE key = $1", key).Scan(&cached))
+	testAssert(t, cached == 0)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+type interaction struct {
+	Request  openai.ChatCompletionRequest  `json:"request"`
+	Response openai.ChatCompletionResponse `json:"response"`
+}
+
+type cassette struct {
+	Interactions []interaction `json:"interactions"`
+}
+
+func loadCassette(path string) cassette {
+	var c cassette
+	fail(json.Unmarshal([]byte(readFile(path)), &c))
+	return c
+}
+
+func (c cassette) save(path string) {
+	fail(os.WriteFile(path, append(unwrap(json.MarshalIndent(c, "", "  ")), '\n'), 0644))
+}
+
+// canonicalJSON marshals x such that two requests that are equal on the wire are equal byte-for-byte, even if one of
+// them went through a cassette (e.g., tool parameters are structs before recording but maps after replaying)
+func canonicalJSON(x any) []byte {
+	var v any
+	fail(json.Unmarshal(unwrap(json.Marshal(x)), &v))
+	return unwrap(json.Marshal(v))
+}
+
+// recorder passes every request through to inner and appends the request/response pair to the cassette at path. The
+// cassette is rewritten after every interaction so that nothing is lost if the process dies midway.
+type recorder struct {
+	inner    provider
+	path     string
+	cassette cassette
+}
+
+func newRecorder(inner provider, path string) *recorder {
+	return &recorder{inner: inner, path: path}
+}
+
+func (r *recorder) CreateChatCompletion(ctx context.Context,
+	request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+
+	response, err := r.inner.CreateChatCompletion(ctx, request)
+	if err != nil {
+		return response, err
+	}
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction{request, response})
+	r.cassette.save(r.path)
+	return response, nil
+}
+
+// replayer serves the interactions of a cassette in the order they were recorded. A request that doesn't match the
+// next recorded request (or any request after the last one) is an error, so a prompt change can't silently pass.
+type replayer struct {
+	path     string
+	cassette cassette
+	next     int
+}
+
+func newReplayer(path string) *replayer {
+	return &replayer{path: path, cassette: loadCassette(path)}
+}
+
+func (r *replayer) CreateChatCompletion(_ context.Context,
+	request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+
+	if r.next >= len(r.cassette.Interactions) {
+		return openai.ChatCompletionResponse{},
+			fmt.Errorf("cassette %s: unexpected request #%d (only %d recorded)", r.path, r.next+1, len(r.cassette.Interactions))
+	}
+	recorded := r.cassette.Interactions[r.next]
+	want, got := canonicalJSON(recorded.Request), canonicalJSON(request)
+	if !bytes.Equal(want, got) {
+		return openai.ChatCompletionResponse{},
+			fmt.Errorf("cassette %s: request #%d does not match the recording\nwant: %s\ngot:  %s", r.path, r.next+1, want, got)
+	}
+	r.next++
+	return recorded.Response, nil
+}
+
+// done reports whether every recorded interaction was replayed
+func (r *replayer) done() bool {
+	return r.next == len(r.cassette.Interactions)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// stubProvider answers every request with a reply derived from the last message, so that different prompts produce
+// different responses
+type stubProvider struct {
+	n int
+}
+
+func (s *stubProvider) CreateChatCompletion(_ context.Context,
+	request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+
+	s.n++
+	content := request.Messages[len(request.Messages)-1].Content
+	return openai.ChatCompletionResponse{
+		Model: request.Model,
+		Choices: []openai.ChatCompletionChoice{
+			{Message: openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleAssistant,
+				Content: fmt.Sprintf("Stub reply #%d to a %d-byte prompt", s.n, len(content)),
+			}},
+		},
+	}, nil
+}
+
+func testRequest(content string) openai.ChatCompletionRequest {
+	return openai.ChatCompletionRequest{
+		Model:     openai.GPT4,
+		MaxTokens: 200,
+		Messages:  []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: content}},
+	}
+}
+
+func TestCassetteReplay(t *testing.T) {
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	recorder := newRecorder(&stubProvider{}, path)
+	first := unwrap(recorder.CreateChatCompletion(ctx, testRequest("Where is Kris?")))
+	second := unwrap(recorder.CreateChatCompletion(ctx, testRequest("What does Kris do?")))
+
+	replayer := newReplayer(path)
+	testAssert(t, unwrap(replayer.CreateChatCompletion(ctx, testRequest("Where is Kris?"))).Choices[0].Message.Content ==
+		first.Choices[0].Message.Content)
+	testAssert(t, unwrap(replayer.CreateChatCompletion(ctx, testRequest("What does Kris do?"))).Choices[0].Message.Content ==
+		second.Choices[0].Message.Content)
+	testAssert(t, replayer.done())
+
+	// past the end of the cassette
+	_, err := replayer.CreateChatCompletion(ctx, testRequest("Where is Kris?"))
+	testAssert(t, err != nil)
+
+	// out of order
+	replayer = newReplayer(path)
+	_, err = replayer.CreateChatCompletion(ctx, testRequest("What does Kris do?"))
+	testAssert(t, err != nil)
+
+	// same question, different parameters
+	replayer = newReplayer(path)
+	request := testRequest("Where is Kris?")
+	request.MaxTokens = 100
+	_, err = replayer.CreateChatCompletion(ctx, request)
+	testAssert(t, err != nil)
+}
+
+func TestAnswerQuestionReplay(t *testing.T) {
+
+	settings := getSettings()
+	settings.falseResponse = false
+
+	// Replays need neither the network nor resume.pdf, nor do they touch the real rows
+	ctx := context.Background()
+	conn := setupTestDB(t, ctx)
+	stubResume(t, testResume)
+
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	question := "Where is Kris?"
+	debugMode := __debugModeOff
+
+	recorded := answerQuestion(uuid.NewString(), uuid.NewString(), question, "", settings, ctx, conn,
+		newRecorder(&stubProvider{}, path), debugMode).Text
+
+	// A fresh visitor asking the same question produces the same prompt, so the recording must be served back
+	replayer := newReplayer(path)
+	testAssert(t, answerQuestion(uuid.NewString(), uuid.NewString(), question, "", settings, ctx, conn, replayer,
+		debugMode).Text == recorded)
+	testAssert(t, replayer.done())
+
+	// A different question produces a different prompt, which must not be served from the cassette
+	func() {
+		defer func() {
+			testAssert(t, recover() != nil)
+		}()
+		answerQuestion(uuid.NewString(), uuid.NewString(), "What does Kris do?", "", settings, ctx, conn,
+			newReplayer(path), debugMode)
+	}()
+}
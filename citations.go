@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// Without this file, answers don't come with citations
+const citationFile = "citations.yaml"
+
+type citationConfig struct {
+	// A claim is supported by the knowledge chunk it's most similar to, if it's at least this similar (0-1)
+	MinSimilarity float64 `yaml:"min-similarity"`
+	// Inline citations add [n] after every supported claim
+	Inline bool `yaml:"inline"`
+	// allow: answers are shown as-is, even with unsupported claims
+	// flag: unsupported claims are listed with the answer, and recorded as guard incidents
+	// reject: answers with unsupported claims are replaced with the rejection, and recorded as guard incidents
+	Unsupported string `yaml:"unsupported"`
+	Rejection   string `yaml:"rejection"`
+}
+
+var unsupportedModes = map[string]bool{"allow": true, "flag": true, "reject": true}
+
+// citation points at the knowledge chunk that supports part of an answer
+type citation struct {
+	Section string `json:"section"`
+	Snippet string `json:"snippet"`
+	Source  string `json:"source"`
+}
+
+// A chunk is a paragraph of the resume, or a fact
+type chunk struct {
+	section string
+	source  string
+	text    string
+}
+
+// loadCitationConfig reads citations.yaml, e.g.:
+//
+//	min-similarity: 0.3
+//	inline: true
+//	unsupported: flag
+func loadCitationConfig() *citationConfig {
+	if !fileExists(citationFile) {
+		return nil
+	}
+	var config citationConfig
+	decoder := yaml.NewDecoder(strings.NewReader(readFile(citationFile)))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil {
+		log.Fatalf("%s: %v", citationFile, err)
+	}
+	if config.MinSimilarity <= 0 || config.MinSimilarity > 1 {
+		log.Fatalf("%s: min-similarity should be between 0 and 1", citationFile)
+	}
+	if config.Unsupported == "" {
+		config.Unsupported = "allow"
+	}
+	if !unsupportedModes[config.Unsupported] {
+		log.Fatalf("%s: Invalid unsupported mode '%s'; should be allow, flag or reject", citationFile,
+			config.Unsupported)
+	}
+	if config.Rejection == "" {
+		config.Rejection = "Sorry, but I'm not sure about that. Is there anything else you'd like to know about Kris?"
+	}
+	return &config
+}
+
+// isResumeHeading tells whether a line of the resume is a section heading, like "WORK EXPERIENCE"
+func isResumeHeading(line string) bool {
+	letters := false
+	for _, r := range line {
+		if unicode.IsLower(r) {
+			return false
+		}
+		letters = letters || unicode.IsLetter(r)
+	}
+	return letters && len(strings.Fields(line)) <= 4
+}
+
+func titleCase(heading string) string {
+	words := strings.Fields(strings.ToLower(heading))
+	for i, word := range words {
+		runes := []rune(word)
+		words[i] = string(unicode.ToUpper(runes[0])) + string(runes[1:])
+	}
+	return strings.Join(words, " ")
+}
+
+// knowledgeChunks splits the knowledge in the prompt into chunks that answers can cite: the resume's paragraphs,
+// under the section headings they come after, and the facts
+func knowledgeChunks(resume string, facts []string) []chunk {
+	var chunks []chunk
+	section := "Resume"
+	for _, paragraph := range strings.Split(strings.ReplaceAll(resume, "\r\n", "\n"), "\n\n") {
+		var lines []string
+		for _, line := range strings.Split(paragraph, "\n") {
+			if line = strings.TrimSpace(line); line == "" {
+				continue
+			}
+			if isResumeHeading(line) {
+				section = titleCase(line)
+				continue
+			}
+			lines = append(lines, line)
+		}
+		if len(lines) > 0 {
+			chunks = append(chunks, chunk{section, "resume.pdf", strings.Join(lines, " ")})
+		}
+	}
+	for _, fact := range facts {
+		chunks = append(chunks, chunk{"Facts", "main.go", fact})
+	}
+	return chunks
+}
+
+// knowledge is everything answers can be supported by: the resume, the facts, and career.yaml
+func knowledge() []chunk {
+	return append(knowledgeChunks(resume(), facts), loadCareer().chunks()...)
+}
+
+var sentencePattern = regexp.MustCompile(`[^.!?\n]+[.!?]*`)
+
+// claims splits an answer into sentences, leaving out the ones that don't claim anything (questions, and sentences
+// with fewer than 3 meaningful words, like "Sure!")
+func claims(text string) []string {
+	var sentences []string
+	for _, s := range sentencePattern.FindAllString(text, -1) {
+		if s = strings.TrimSpace(s); s != "" && !strings.HasSuffix(s, "?") && len(topicWords(s)) >= 3 {
+			sentences = append(sentences, s)
+		}
+	}
+	return sentences
+}
+
+// snippet shortens a chunk to at most 160 characters, on a word boundary
+func snippet(text string) string {
+	const maxLength = 160
+	runes := []rune(text)
+	if len(runes) <= maxLength {
+		return text
+	}
+	cut := string(runes[:maxLength])
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+	return cut + "…"
+}
+
+// citedAnswer is an answer with its citations, and the claims that no chunk supports
+type citedAnswer struct {
+	text        string
+	citations   []citation
+	unsupported []string
+}
+
+// cite finds the chunk that supports each claim of an answer. With inline citations, the text gets [n] markers.
+func (config citationConfig) cite(response string, chunks []chunk) citedAnswer {
+	examples := make(map[string][]string)
+	for i, c := range chunks {
+		examples[strconv.Itoa(i)] = []string{c.text}
+	}
+	model := trainTFIDF(examples)
+
+	cited := citedAnswer{text: response}
+	numbers := make(map[int]int) // chunk -> citation number
+	for _, claim := range claims(response) {
+		label, similarity := model.classify(claim)
+		i, err := strconv.Atoi(label)
+		if err != nil || similarity < config.MinSimilarity {
+			cited.unsupported = append(cited.unsupported, claim)
+			continue
+		}
+		if _, ok := numbers[i]; !ok {
+			cited.citations = append(cited.citations, citation{chunks[i].section, snippet(chunks[i].text),
+				chunks[i].source})
+			numbers[i] = len(cited.citations)
+		}
+		if config.Inline {
+			cited.text = strings.Replace(cited.text, claim, fmt.Sprintf("%s [%d]", claim, numbers[i]), 1)
+		}
+	}
+	return cited
+}
+
+// citeAnswer applies citations.yaml to an answer from the model. It returns the text to show the visitor (which is the
+// rejection if the answer is rejected), the citations, and the unsupported claims to flag. Claims are matched to
+// chunks by their words, so only answers in English (the language of the knowledge) are cited.
+func citeAnswer(ctx context.Context, conn *pgx.Conn, uuid, response, language string) (string, []citation, []string) {
+	config := loadCitationConfig()
+	if config == nil || language != defaultLanguage {
+		return response, nil, nil
+	}
+	cited := config.cite(response, knowledge())
+	if len(cited.unsupported) == 0 || config.Unsupported == "allow" {
+		return cited.text, cited.citations, nil
+	}
+	recordGuardIncident(ctx, conn, uuid, "citations", fmt.Sprintf("%s: %d unsupported claims", config.Unsupported,
+		len(cited.unsupported)), response)
+	if config.Unsupported == "reject" {
+		return localize(language, config.Rejection), nil, nil
+	}
+	return cited.text, cited.citations, cited.unsupported
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+const testResume = `KRIS CHERVEN
+Software engineer in Detroit, Michigan
+
+WORK EXPERIENCE
+Backend Engineer, Acme Corp (2021-2023)
+Built payment services in Go and PostgreSQL.
+
+EDUCATION
+Grand Circus Java Bootcamp (2020)
+`
+
+func TestKnowledgeChunks(t *testing.T) {
+
+	chunks := knowledgeChunks(testResume, []string{"Kris Cherven is 24 years old."})
+	testAssert(t, len(chunks) == 4)
+	testAssert(t, chunks[0].section == "Kris Cherven" && chunks[0].text == "Software engineer in Detroit, Michigan")
+	testAssert(t, chunks[1].section == "Work Experience" && chunks[1].source == "resume.pdf" &&
+		chunks[1].text == "Backend Engineer, Acme Corp (2021-2023) Built payment services in Go and PostgreSQL.")
+	testAssert(t, chunks[2].section == "Education")
+	testAssert(t, chunks[3].section == "Facts" && chunks[3].source == "main.go")
+}
+
+func TestCite(t *testing.T) {
+
+	chunks := knowledgeChunks(testResume, []string{"Kris Cherven is 24 years old."})
+	config := citationConfig{MinSimilarity: 0.3, Inline: true}
+
+	cited := config.cite("Sure! Kris built payment services in Go at Acme Corp. He went to Grand Circus Java Bootcamp. "+
+		"He is 24 years old. Kris also won an Olympic medal in fencing. Anything else?", chunks)
+	testAssert(t, len(cited.citations) == 3)
+	testAssert(t, cited.citations[0].Section == "Work Experience" && cited.citations[1].Section == "Education" &&
+		cited.citations[2].Section == "Facts")
+	testAssert(t, len(cited.unsupported) == 1 && cited.unsupported[0] == "Kris also won an Olympic medal in fencing.")
+	testAssert(t, cited.text == "Sure! Kris built payment services in Go at Acme Corp. [1] He went to Grand Circus "+
+		"Java Bootcamp. [2] He is 24 years old. [3] Kris also won an Olympic medal in fencing. Anything else?")
+
+	long := snippet(strings.Repeat("Kris built things. ", 20))
+	testAssert(t, strings.HasSuffix(long, " Kris…") && utf8.RuneCountInString(long) <= 161)
+	testAssert(t, len(claims("Sure! Anything else?")) == 0)
+}
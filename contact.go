@@ -0,0 +1,38 @@
+package main
+
+import (
+	"regexp"
+)
+
+type contactDetail struct {
+	kind  string
+	value string
+}
+
+var (
+	linkedinPattern = regexp.MustCompile(`(?i)(?:https?://)?(?:[a-z]{2,3}\.)?linkedin\.com/(?:in|company)/[a-z0-9_%-]+/?`)
+	emailPattern    = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	phonePattern    = regexp.MustCompile(`(?:\+\d{1,3}[\s.-]?)?(?:\(\d{3}\)\s?|\b\d{3}[\s.-]?)\d{3}[\s.-]?\d{4}\b`)
+)
+
+// Order matters: a LinkedIn URL is matched before anything inside it can be mistaken for something else
+var contactPatterns = []struct {
+	kind    string
+	pattern *regexp.Regexp
+}{
+	{"linkedin", linkedinPattern},
+	{"email", emailPattern},
+	{"phone", phonePattern},
+}
+
+// detectContact finds the contact details (emails, phone numbers, LinkedIn URLs) a visitor typed into a question
+func detectContact(question string) []contactDetail {
+	var details []contactDetail
+	for _, p := range contactPatterns {
+		for _, match := range p.pattern.FindAllString(question, -1) {
+			details = append(details, contactDetail{p.kind, match})
+		}
+		question = p.pattern.ReplaceAllString(question, " ")
+	}
+	return details
+}
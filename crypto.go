@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// The master key is 32 random bytes, base64-encoded, in $PORTFOLIO_CHATBOT_KEY or the file ENCRYPTION_KEY, e.g.:
+//
+//	head -c 32 /dev/urandom | base64 > ENCRYPTION_KEY
+const (
+	keyEnvVar  = "PORTFOLIO_CHATBOT_KEY"
+	keyFile    = "ENCRYPTION_KEY"
+	sealPrefix = "enc1:"
+	// Plaintext that starts with sealPrefix (e.g., a visitor's question) is stored with this prefix, so that it isn't
+	// mistaken for sealed text (see sealText)
+	plainPrefix = "txt1:"
+)
+
+// masterKey returns nil if no key is configured
+func masterKey() []byte {
+	encoded := os.Getenv(keyEnvVar)
+	if encoded == "" && fileExists(keyFile) {
+		encoded = readFile(keyFile)
+	}
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		return nil
+	}
+	return decodeKey(encoded)
+}
+
+func decodeKey(encoded string) []byte {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != 32 {
+		log.Fatalf("Invalid encryption key: should be 32 bytes, base64-encoded")
+	}
+	return key
+}
+
+func keyID(key []byte) []byte {
+	sum := sha256.Sum256(key)
+	return sum[:8]
+}
+
+func gcm(key []byte) cipher.AEAD {
+	return unwrap(cipher.NewGCM(unwrap(aes.NewCipher(key))))
+}
+
+// gcmSeal encrypts plaintext with a fresh nonce, which it prepends to the ciphertext
+func gcmSeal(key, plaintext []byte) []byte {
+	aead := gcm(key)
+	nonce := make([]byte, aead.NonceSize())
+	unwrap(rand.Read(nonce))
+	return aead.Seal(nonce, nonce, plaintext, nil)
+}
+
+func gcmOpen(key, sealed []byte) ([]byte, error) {
+	aead := gcm(key)
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
+
+// seal encrypts plaintext with envelope encryption: a fresh data key encrypts the plaintext, and the master key
+// encrypts (wraps) the data key. The result is "enc1:" + base64(key id | wrapped data key | ciphertext), where the key
+// id tells which master key can unwrap the data key.
+func seal(key []byte, plaintext string) string {
+	dataKey := randomSecret()
+	wrapped := gcmSeal(key, dataKey)
+	ciphertext := gcmSeal(dataKey, []byte(plaintext))
+
+	envelope := append(append(keyID(key), wrapped...), ciphertext...)
+	return sealPrefix + base64.StdEncoding.EncodeToString(envelope)
+}
+
+func isSealed(s string) bool {
+	return strings.HasPrefix(s, sealPrefix)
+}
+
+// sealedWith tells whether the output of seal was sealed with a key
+func sealedWith(sealed string, key []byte) bool {
+	envelope, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, sealPrefix))
+	return err == nil && isSealed(sealed) && len(envelope) >= 8 && key != nil && hmac.Equal(envelope[:8], keyID(key))
+}
+
+var errWrongKey = errors.New("sealed with a different key")
+
+// openEnvelope unwraps the data key of the output of seal with whichever of the keys it was sealed with
+func openEnvelope(sealed string, keys ...[]byte) (dataKey, ciphertext []byte, err error) {
+	envelope, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, sealPrefix))
+	if err != nil {
+		return nil, nil, err
+	}
+	// key id + wrapped data key (nonce + 32 bytes + tag)
+	wrappedLen := 8 + 12 + 32 + 16
+	if !isSealed(sealed) || len(envelope) < wrappedLen {
+		return nil, nil, errors.New("not sealed")
+	}
+	for _, key := range keys {
+		if key == nil || !hmac.Equal(envelope[:8], keyID(key)) {
+			continue
+		}
+		dataKey, err := gcmOpen(key, envelope[8:wrappedLen])
+		return dataKey, envelope[wrappedLen:], err
+	}
+	return nil, nil, errWrongKey
+}
+
+// unseal decrypts the output of seal with whichever of the keys it was sealed with
+func unseal(sealed string, keys ...[]byte) (string, error) {
+	dataKey, ciphertext, err := openEnvelope(sealed, keys...)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := gcmOpen(dataKey, ciphertext)
+	return string(plaintext), err
+}
+
+// rewrap re-encrypts the data key of the output of seal with a new master key. The ciphertext itself doesn't change,
+// which is the point of envelope encryption: rotating the master key doesn't mean decrypting everything.
+func rewrap(sealed string, newKey []byte, oldKeys ...[]byte) (string, error) {
+	dataKey, ciphertext, err := openEnvelope(sealed, append([][]byte{newKey}, oldKeys...)...)
+	if err != nil {
+		return "", err
+	}
+	envelope := append(append(keyID(newKey), gcmSeal(newKey, dataKey)...), ciphertext...)
+	return sealPrefix + base64.StdEncoding.EncodeToString(envelope), nil
+}
+
+// sealText prepares text for storage: it's sealed if there is a key, and escaped if it would otherwise look sealed
+func sealText(key []byte, text string) string {
+	if key != nil {
+		return seal(key, text)
+	}
+	if strings.HasPrefix(text, sealPrefix) || strings.HasPrefix(text, plainPrefix) {
+		return plainPrefix + text
+	}
+	return text
+}
+
+// openText reverses sealText
+func openText(stored string, keys ...[]byte) (string, error) {
+	if strings.HasPrefix(stored, plainPrefix) {
+		return strings.TrimPrefix(stored, plainPrefix), nil
+	}
+	if isSealed(stored) {
+		return unseal(stored, keys...)
+	}
+	return stored, nil
+}
+
+func randomSecret() []byte {
+	secret := make([]byte, 32)
+	unwrap(rand.Read(secret))
+	return secret
+}
+
+// sealSecret encodes a secret (e.g., a signing key) for storage, sealed if there is a key
+func sealSecret(key, secret []byte) string {
+	encoded := base64.StdEncoding.EncodeToString(secret)
+	if key == nil {
+		return encoded
+	}
+	return seal(key, encoded)
+}
+
+func unsealSecret(stored string, key []byte) []byte {
+	if isSealed(stored) {
+		stored = unwrap(unseal(stored, key))
+	}
+	return unwrap(base64.StdEncoding.DecodeString(stored))
+}
+
+// blindIndex is a keyed hash of a value, for finding or de-duplicating sealed values without decrypting them. With a
+// nil key, it's no better than an unkeyed hash.
+func blindIndex(key []byte, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// rotateKeyCommand re-encrypts everything with the current key. To rotate keys, move the old key file aside, create a
+// new one, and run:
+//
+//	./portfolio-chatbot rotate-key -old ENCRYPTION_KEY.old
+//
+// Without -old, it only encrypts the rows from before there was a key.
+func rotateKeyCommand(args []string, settings settings, ctx context.Context, conn *pgx.Conn) {
+	flags := flag.NewFlagSet("rotate-key", flag.ExitOnError)
+	oldKeyFile := flags.String("old", "", "file with the previous key")
+	fail(flags.Parse(args))
+
+	key := masterKey()
+	if key == nil {
+		log.Fatalf("No encryption key: set $%s or create %s", keyEnvVar, keyFile)
+	}
+	var oldKey []byte
+	if *oldKeyFile != "" {
+		oldKey = decodeKey(readFile(*oldKeyFile))
+	}
+	for table, count := range rotateKey(ctx, conn, key, oldKey) {
+		fmt.Printf("Re-encrypted %d rows in %s\n", count, table)
+	}
+}
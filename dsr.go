@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// A data subject is whoever a data access or deletion request is about: a visitor (by uuid), or everyone who asked
+// questions from an IP address (by ipAddrHash)
+type dataSubject struct {
+	kind       string // "uuid" or "ipAddrHash"
+	id         string
+	uuids      []string
+	ipAddrHash string
+}
+
+func findDataSubject(ctx context.Context, conn *pgx.Conn, kind, id string) dataSubject {
+	if kind == "ipAddrHash" {
+		return dataSubject{kind, id, visitorsByIpAddrHash(ctx, conn, id), id}
+	}
+	return dataSubject{kind, id, []string{id}, ""}
+}
+
+type subjectData struct {
+	Subject     string                       `json:"subject"`
+	SubjectKind string                       `json:"subjectKind"`
+	ExportedAt  time.Time                    `json:"exportedAt"`
+	Visitors    []string                     `json:"visitors"`
+	Tables      map[string][]json.RawMessage `json:"tables"`
+}
+
+func (subject dataSubject) export(ctx context.Context, conn *pgx.Conn) subjectData {
+	tables := visitorRows(ctx, conn, subject.uuids, subject.ipAddrHash)
+	key := masterKey()
+	for _, rows := range tables {
+		for i, row := range rows {
+			rows[i] = unsealRow(row, key)
+		}
+	}
+	return subjectData{subject.id, subject.kind, time.Now(), subject.uuids, tables}
+}
+
+// Columns that were sealed with a key that has since been rotated out are exported as this
+const unreadableColumn = "(unreadable: encrypted with a key that is no longer available)"
+
+// unsealRow decrypts a row's sealed columns (see crypto.go), since the visitor is entitled to their data, not to
+// ciphertext. A column that can't be decrypted is marked as unreadable, rather than failing the whole request.
+func unsealRow(row json.RawMessage, key []byte) json.RawMessage {
+	var columns map[string]any
+	fail(json.Unmarshal(row, &columns))
+	for column, value := range columns {
+		s, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var err error
+		if column == "message" {
+			columns[column], err = openMessage(s, key)
+		} else {
+			columns[column], err = openText(s, key)
+		}
+		if err != nil {
+			log.Warnf("Exporting an unreadable %s: %v", column, err)
+			columns[column] = unreadableColumn
+		}
+	}
+	return unwrap(json.Marshal(columns))
+}
+
+func (subject dataSubject) erase(ctx context.Context, conn *pgx.Conn, requestedBy string) map[string]int64 {
+	return eraseVisitors(ctx, conn, subject.uuids, subject.ipAddrHash, subject.kind, subject.id, requestedBy)
+}
+
+// dataCommand handles data subject requests from the command line:
+//
+//	./portfolio-chatbot data export [-ip] {uuid|ipAddrHash}
+//	./portfolio-chatbot data erase [-ip] {uuid|ipAddrHash}
+func dataCommand(args []string, settings settings, ctx context.Context, conn *pgx.Conn) {
+	usage := func() {
+		fmt.Println("Error: Wrong format: Should be ./portfolio-chatbot data {export|erase} [-ip] {uuid|ipAddrHash}.")
+		os.Exit(2)
+	}
+	if len(args) == 0 || (args[0] != "export" && args[0] != "erase") {
+		usage()
+	}
+	flags := flag.NewFlagSet("data "+args[0], flag.ExitOnError)
+	byIpAddrHash := flags.Bool("ip", false, "the subject is an ipAddrHash rather than a uuid")
+	fail(flags.Parse(args[1:]))
+	if flags.NArg() != 1 {
+		usage()
+	}
+
+	kind := "uuid"
+	if *byIpAddrHash {
+		kind = "ipAddrHash"
+	}
+	subject := findDataSubject(ctx, conn, kind, flags.Arg(0))
+
+	if args[0] == "export" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		fail(encoder.Encode(subject.export(ctx, conn)))
+	} else {
+		for table, count := range subject.erase(ctx, conn, "cli") {
+			fmt.Printf("Deleted %d rows from %s\n", count, table)
+		}
+	}
+}
+
+// GET /data downloads everything stored about the visitor, and DELETE /data erases it
+func (s *server) handleData(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uuid, err := s.sessionUUID(r)
+	if err != nil {
+		httpError(w, http.StatusUnauthorized, "%v", err)
+		return
+	}
+	subject := findDataSubject(s.ctx, s.conn, "uuid", uuid)
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Disposition", `attachment; filename="portfolio-chatbot-data.json"`)
+		writeJSON(w, http.StatusOK, subject.export(s.ctx, s.conn))
+	case http.MethodDelete:
+		writeJSON(w, http.StatusOK, map[string]any{"deleted": subject.erase(s.ctx, s.conn, "visitor")})
+	default:
+		httpError(w, http.StatusMethodNotAllowed, "use GET or DELETE")
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestEraseVisitor(t *testing.T) {
+
+	ctx := context.Background()
+	conn := setupDB(ctx)
+	defer conn.Close(ctx)
+	// With redaction.yaml, email leads are only stored with a key (see storeLeads)
+	t.Setenv(keyEnvVar, base64.StdEncoding.EncodeToString(randomSecret()))
+
+	uuid_ := uuid.NewString()
+	otherUUID := uuid.NewString()
+	ipAddrHash := uuid.NewString()
+	debugMode := __debugModeOff
+
+	answerQuestion(uuid_, ipAddrHash, "Email me at jane@example.com", "", getSettings(), ctx, conn, nil, debugMode)
+	answerQuestion(otherUUID, ipAddrHash, "Where is Kris?", "", getSettings(), ctx, conn, nil, debugMode)
+	// Webhook sink ids contain slashes, so notifications are found by their uuid column rather than by their key
+	webhook := notifyConfig{NewSession: true, Sinks: []sinkConfig{{Type: "webhook", URL: "https://example.com/a/b"}}}
+	webhook.enqueueNotifications(ctx, conn, uuid_, "Hi", "Hi", nil, 1)
+
+	subject := findDataSubject(ctx, conn, "ipAddrHash", ipAddrHash)
+	testAssert(t, len(subject.uuids) == 2)
+
+	data := subject.export(ctx, conn)
+	testAssert(t, len(data.Tables["message_queue"]) == 4)
+	testAssert(t, len(data.Tables["leads"]) == 1)
+	testAssert(t, len(data.Tables["ratelimit"]) == 3)
+	testAssert(t, len(data.Tables["notifications"]) >= 1)
+
+	subject.erase(ctx, conn, "test")
+	for table, rows := range findDataSubject(ctx, conn, "uuid", uuid_).export(ctx, conn).Tables {
+		if len(rows) != 0 {
+			t.Errorf("%s still has %d rows", table, len(rows))
+		}
+	}
+	testAssert(t, len(visitorRows(ctx, conn, []string{otherUUID}, ipAddrHash)["ratelimit"]) == 0)
+
+	var erasures int
+	fail(conn.QueryRow(ctx, "SELECT count(*) FROM erasures WHERE requested_by = 'test' AND subject_hash != $1",
+		ipAddrHash).Scan(&erasures))
+	testAssert(t, erasures > 0)
+}
+
+func TestUnsealRow(t *testing.T) {
+
+	key, retiredKey := randomSecret(), randomSecret()
+	row := func(columns map[string]any) json.RawMessage {
+		return unwrap(json.Marshal(columns))
+	}
+	unsealed := func(row json.RawMessage) map[string]any {
+		var columns map[string]any
+		fail(json.Unmarshal(unsealRow(row, key), &columns))
+		return columns
+	}
+
+	columns := unsealed(row(map[string]any{"message": sealMessage(key, "USER: Where is Kris?"),
+		"value": sealText(key, "jane@example.com"), "id": 1}))
+	testAssert(t, columns["message"] == "USER: Where is Kris?" && columns["value"] == "jane@example.com")
+
+	// Columns sealed with a retired key don't take the rest of the export down with them
+	columns = unsealed(row(map[string]any{"message": sealMessage(retiredKey, "USER: Where is Kris?"),
+		"value": sealText(retiredKey, "jane@example.com"), "kind": "email"}))
+	testAssert(t, columns["message"] == unreadableColumn && columns["value"] == unreadableColumn &&
+		columns["kind"] == "email")
+}
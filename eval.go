@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// An evalQuestion is scored by its checks: every expected fact (case-insensitive; alternatives are separated by "|")
+// and every required phrase (case-sensitive) must appear in the answer, and no forbidden phrase (case-insensitive) may
+type evalQuestion struct {
+	ID               string   `yaml:"id"`
+	Question         string   `yaml:"question"`
+	ExpectedFacts    []string `yaml:"expected-facts"`
+	RequiredPhrases  []string `yaml:"required-phrases"`
+	ForbiddenPhrases []string `yaml:"forbidden-phrases"`
+}
+
+type evalSuite struct {
+	Name      string         `yaml:"name"`
+	Questions []evalQuestion `yaml:"questions"`
+}
+
+type evalResult struct {
+	ID       string   `json:"id"`
+	Question string   `json:"question"`
+	Answer   string   `json:"answer"`
+	Score    float64  `json:"score"`
+	Failures []string `json:"failures"`
+}
+
+type evalRun struct {
+	Suite    string       `json:"suite"`
+	Provider string       `json:"provider"`
+	Time     time.Time    `json:"time"`
+	Score    float64      `json:"score"`
+	Results  []evalResult `json:"results"`
+}
+
+func loadEvalSuite(path string) evalSuite {
+	var suite evalSuite
+	decoder := yaml.NewDecoder(strings.NewReader(readFile(path)))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&suite); err != nil {
+		log.Fatalf("%s: %v", path, err)
+	}
+	for i, q := range suite.Questions {
+		if strings.TrimSpace(q.Question) == "" {
+			log.Fatalf("%s: Question #%d is empty", path, i+1)
+		}
+		if q.ID == "" {
+			suite.Questions[i].ID = q.Question
+		}
+	}
+	return suite
+}
+
+func scoreAnswer(q evalQuestion, answer string) (float64, []string) {
+	var failures []string
+	checks := 0
+	lower := strings.ToLower(answer)
+
+	for _, fact := range q.ExpectedFacts {
+		checks++
+		found := false
+		for _, alternative := range strings.Split(fact, "|") {
+			if strings.Contains(lower, strings.ToLower(strings.TrimSpace(alternative))) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			failures = append(failures, fmt.Sprintf("missing expected fact %q", fact))
+		}
+	}
+	for _, phrase := range q.RequiredPhrases {
+		checks++
+		if !strings.Contains(answer, phrase) {
+			failures = append(failures, fmt.Sprintf("missing required phrase %q", phrase))
+		}
+	}
+	for _, phrase := range q.ForbiddenPhrases {
+		checks++
+		if strings.Contains(lower, strings.ToLower(phrase)) {
+			failures = append(failures, fmt.Sprintf("contains forbidden phrase %q", phrase))
+		}
+	}
+
+	if checks == 0 {
+		return 1, nil
+	}
+	return float64(checks-len(failures)) / float64(checks), failures
+}
+
+func runEval(suite evalSuite, settings settings, ctx context.Context, conn *pgx.Conn, client provider) []evalResult {
+	var results []evalResult
+	for _, q := range suite.Questions {
+		// Every question gets a fresh visitor, so answers don't depend on each other and never hit the rate limit
+		answer := answerQuestion(uuid.NewString(), uuid.NewString(), q.Question, "", settings, ctx, conn, client,
+			__debugModeOff).Text
+		score, failures := scoreAnswer(q, answer)
+		results = append(results, evalResult{q.ID, q.Question, answer, score, failures})
+	}
+	return results
+}
+
+func printEvalReport(run evalRun, previous *evalRun) {
+	previousResults := make(map[string]evalResult)
+	if previous != nil {
+		for _, result := range previous.Results {
+			previousResults[result.ID] = result
+		}
+	}
+
+	for _, result := range run.Results {
+		old, hadOld := previousResults[result.ID]
+		if hadOld && old.Score != result.Score {
+			fmt.Printf("%.2f (was %.2f)  %s\n", result.Score, old.Score, result.ID)
+		} else if hadOld {
+			fmt.Printf("%.2f              %s\n", result.Score, result.ID)
+		} else {
+			fmt.Printf("%.2f (new)        %s\n", result.Score, result.ID)
+		}
+		for _, failure := range result.Failures {
+			fmt.Printf("    FAIL %s\n", failure)
+		}
+		if hadOld && old.Answer != result.Answer {
+			fmt.Printf("    - %s\n", strings.ReplaceAll(old.Answer, "\n", "\n    - "))
+			fmt.Printf("    + %s\n", strings.ReplaceAll(result.Answer, "\n", "\n    + "))
+		}
+	}
+
+	if previous != nil {
+		fmt.Printf("TOTAL %.2f (was %.2f, %+.2f) over %d questions\n", run.Score, previous.Score,
+			run.Score-previous.Score, len(run.Results))
+	} else {
+		fmt.Printf("TOTAL %.2f over %d questions\n", run.Score, len(run.Results))
+	}
+}
+
+func evalCommand(args []string, settings settings, ctx context.Context, conn *pgx.Conn) {
+	flags := flag.NewFlagSet("eval", flag.ExitOnError)
+	providerSpec := flags.String("provider", "openai", "where answers come from: "+providerSpecUsage)
+	resultsPath := flags.String("results", "eval/last-run.json", "results of the previous run, overwritten by this run")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: ./portfolio-chatbot eval [flags] {suite.yaml}")
+		flags.PrintDefaults()
+	}
+	fail(flags.Parse(args))
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	suite := loadEvalSuite(flags.Arg(0))
+	client := providerFromSpec(*providerSpec)
+
+	// Evaluate the answers themselves, not whether the chatbot happens to be switched off right now
+	settings.chatbotEnabled = true
+	settings.falseResponse = false
+
+	// Evaluation visitors aren't real: they run in a schema of their own, which is dropped afterwards, so that they
+	// don't fire notifications, leave leads behind or count towards the usage statistics
+	schema := fmt.Sprintf("eval_%d", time.Now().UnixNano())
+	conn = setupDBSchema(ctx, schema)
+	defer conn.Close(ctx)
+	defer func() { unwrap(conn.Exec(ctx, "DROP SCHEMA "+schema+" CASCADE")) }()
+
+	run := evalRun{Suite: suite.Name, Provider: *providerSpec, Time: time.Now()}
+	run.Results = runEval(suite, settings, ctx, conn, client)
+	for _, result := range run.Results {
+		run.Score += result.Score
+	}
+	if len(run.Results) > 0 {
+		run.Score /= float64(len(run.Results))
+	}
+
+	var previous *evalRun
+	if fileExists(*resultsPath) {
+		previous = &evalRun{}
+		fail(json.Unmarshal([]byte(readFile(*resultsPath)), previous))
+	}
+	printEvalReport(run, previous)
+
+	fail(os.WriteFile(*resultsPath, append(unwrap(json.MarshalIndent(run, "", "  ")), '\n'), 0644))
+}
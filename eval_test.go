@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestScoreAnswer(t *testing.T) {
+
+	q := evalQuestion{
+		ExpectedFacts:    []string{"Grand Circus", "24 | twenty-four"},
+		RequiredPhrases:  []string{"Java"},
+		ForbiddenPhrases: []string{"facts section"},
+	}
+
+	score, failures := scoreAnswer(q, "Kris (twenty-four) learned Java at grand circus.")
+	testAssert(t, score == 1 && len(failures) == 0)
+
+	// "java" is required verbatim, and the forbidden phrase is matched case-insensitively
+	score, failures = scoreAnswer(q, "According to the Facts Section, Kris learned java at Grand Circus when he was 24.")
+	testAssert(t, score == 0.5 && len(failures) == 2)
+
+	score, failures = scoreAnswer(evalQuestion{}, "Anything")
+	testAssert(t, score == 1 && len(failures) == 0)
+}
+
+func TestLoadEvalSuite(t *testing.T) {
+	suite := loadEvalSuite("eval/suite.yaml")
+	testAssert(t, len(suite.Questions) > 0)
+	for _, q := range suite.Questions {
+		testAssert(t, q.ID != "" && q.Question != "")
+	}
+}
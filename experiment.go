@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	openai "github.com/sashabaranov/go-openai"
+	"gopkg.in/yaml.v3"
+)
+
+const experimentFile = "experiments.yaml"
+
+// An arm decides how a visitor's questions are answered. Empty fields fall back to the prompt-version setting, GPT-4
+// and 200 tokens.
+type arm struct {
+	Name          string `yaml:"name"`
+	Weight        int    `yaml:"weight"`
+	PromptVersion string `yaml:"prompt-version"`
+	Model         string `yaml:"model"`
+	MaxTokens     int    `yaml:"max-tokens"`
+}
+
+// Visitors are bucketed by hashing the experiment name together with their uuid, so renaming the experiment
+// reshuffles everyone while editing the arms only moves the visitors whose bucket changed hands
+type experiment struct {
+	Name string `yaml:"name"`
+	Arms []arm  `yaml:"arms"`
+}
+
+// USD per 1K tokens (prompt, completion)
+var modelPrices = map[string][2]float64{
+	openai.GPT4:             {0.03, 0.06},
+	openai.GPT4TurboPreview: {0.01, 0.03},
+	openai.GPT3Dot5Turbo:    {0.0005, 0.0015},
+}
+
+// loadExperiment reads experiments.yaml. Without it, every visitor is in a single "default" arm.
+func loadExperiment(settings settings) experiment {
+	e := experiment{Name: "none", Arms: []arm{{Name: "default", Weight: 1}}}
+	if fileExists(experimentFile) {
+		decoder := yaml.NewDecoder(strings.NewReader(readFile(experimentFile)))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&e); err != nil {
+			log.Fatalf("%s: %v", experimentFile, err)
+		}
+	}
+
+	if e.Name == "" {
+		log.Fatalf("%s: Missing experiment name", experimentFile)
+	}
+	if len(e.Arms) == 0 {
+		log.Fatalf("%s: Experiment '%s' has no arms", experimentFile, e.Name)
+	}
+	names := make(map[string]bool)
+	totalWeight := 0
+	for i := range e.Arms {
+		a := &e.Arms[i]
+		if a.Name == "" || names[a.Name] {
+			log.Fatalf("%s: Arm #%d has a missing or duplicate name", experimentFile, i+1)
+		}
+		names[a.Name] = true
+		if a.Weight < 0 {
+			log.Fatalf("%s: Arm '%s' has a negative weight", experimentFile, a.Name)
+		}
+		totalWeight += a.Weight
+		if a.PromptVersion == "" {
+			a.PromptVersion = settings.promptVersion
+		}
+		promptTemplate(a.PromptVersion) // fails if the prompt doesn't exist or is invalid
+		if a.Model == "" {
+			a.Model = openai.GPT4
+		}
+		if a.MaxTokens == 0 {
+			a.MaxTokens = 200
+		} else if a.MaxTokens < 1 || a.MaxTokens > 4096 {
+			log.Fatalf("%s: Arm '%s' has out-of-range max-tokens %d", experimentFile, a.Name, a.MaxTokens)
+		}
+	}
+	if totalWeight == 0 {
+		log.Fatalf("%s: Experiment '%s' has no arms with a positive weight", experimentFile, e.Name)
+	}
+	return e
+}
+
+func (e experiment) assignArm(uuid string) arm {
+	totalWeight := 0
+	for _, a := range e.Arms {
+		totalWeight += a.Weight
+	}
+	assert(totalWeight > 0, fmt.Sprintf("experiment '%s' has no arms with a positive weight", e.Name))
+
+	sum := sha256.Sum256([]byte(e.Name + "/" + uuid))
+	bucket := int(binary.BigEndian.Uint64(sum[:8]) % uint64(totalWeight))
+	for _, a := range e.Arms {
+		if bucket < a.Weight {
+			return a
+		}
+		bucket -= a.Weight
+	}
+	panic("unreachable")
+}
+
+// feedbackCommand records a visitor's rating of the answers they got: ./portfolio-chatbot feedback {uuid} {up|down}.
+// The rating goes to the arm that the answers came from, even if the arms have changed since.
+func feedbackCommand(args []string, settings settings, ctx context.Context, conn *pgx.Conn) {
+	if len(args) != 2 || (args[1] != "up" && args[1] != "down") {
+		fmt.Println("Error: Wrong format: Should be ./portfolio-chatbot feedback {uuid} {up|down}.")
+		os.Exit(2)
+	}
+	rating := 1
+	if args[1] == "down" {
+		rating = -1
+	}
+	arm, ok := latestArm(ctx, conn, args[0])
+	if !ok {
+		fmt.Printf("Error: Visitor %s hasn't asked anything yet.\n", args[0])
+		os.Exit(1)
+	}
+	unwrap(conn.Exec(ctx, "INSERT INTO feedback (uuid, arm, rating) VALUES ($1, $2, $3)", args[0], arm, rating))
+}
+
+type armReport struct {
+	visitors         map[string]bool
+	answers          int
+	answerLength     int
+	promptTokens     int
+	completionTokens int
+	cost             float64
+	unpricedModels   map[string]bool
+	thumbsUp         int
+	thumbsDown       int
+	rateLimitHits    int
+}
+
+// reportCommand compares the arms of past experiments: ./portfolio-chatbot report [-since 168h]
+func reportCommand(args []string, settings settings, ctx context.Context, conn *pgx.Conn) {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	since := flags.Duration("since", 7*24*time.Hour, "only count activity this recent")
+	fail(flags.Parse(args))
+
+	query := func(query string, args ...any) pgx.Rows {
+		return unwrap(conn.Query(ctx, query, args...))
+	}
+
+	reports := make(map[string]*armReport)
+	report := func(arm string) *armReport {
+		if reports[arm] == nil {
+			reports[arm] = &armReport{visitors: make(map[string]bool), unpricedModels: make(map[string]bool)}
+		}
+		return reports[arm]
+	}
+	cutoff := time.Now().Add(-*since)
+
+	// From answer_usage rather than message_queue, which is pruned
+	rows := query(`SELECT uuid, arm, model, length, prompt_tokens, completion_tokens
+								 FROM answer_usage
+								 WHERE timestamp_ >= $1 AND arm != ''`, cutoff)
+	for rows.Next() {
+		var uuid, arm, model string
+		var length, promptTokens, completionTokens int
+		fail(rows.Scan(&uuid, &arm, &model, &length, &promptTokens, &completionTokens))
+		r := report(arm)
+		r.visitors[uuid] = true
+		r.answers++
+		r.answerLength += length
+		r.promptTokens += promptTokens
+		r.completionTokens += completionTokens
+		if price, ok := modelPrices[model]; ok {
+			r.cost += (float64(promptTokens)*price[0] + float64(completionTokens)*price[1]) / 1000
+		} else if model != "" {
+			r.unpricedModels[model] = true
+		}
+	}
+	finishRows(rows)
+
+	rows = query("SELECT arm, rating FROM feedback WHERE timestamp_ >= $1", cutoff)
+	for rows.Next() {
+		var arm string
+		var rating int
+		fail(rows.Scan(&arm, &rating))
+		if rating > 0 {
+			report(arm).thumbsUp++
+		} else {
+			report(arm).thumbsDown++
+		}
+	}
+	finishRows(rows)
+
+	rows = query("SELECT arm, count(*) FROM ratelimit_hits WHERE timestamp_ >= $1 GROUP BY arm", cutoff)
+	for rows.Next() {
+		var arm string
+		var count int
+		fail(rows.Scan(&arm, &count))
+		report(arm).rateLimitHits = count
+	}
+	finishRows(rows)
+
+	var arms []string
+	for arm := range reports {
+		arms = append(arms, arm)
+	}
+	sort.Strings(arms)
+
+	fmt.Printf("%-16s %8s %8s %10s %12s %12s %10s %10s %10s\n", "ARM", "VISITORS", "ANSWERS", "AVG LENGTH",
+		"PROMPT TOK", "COMPL. TOK", "COST (USD)", "FEEDBACK", "RATELIMIT")
+	for _, arm := range arms {
+		r := reports[arm]
+		avgLength := 0
+		if r.answers > 0 {
+			avgLength = r.answerLength / r.answers
+		}
+		fmt.Printf("%-16s %8d %8d %10d %12d %12d %10.2f %10s %10d\n", arm, len(r.visitors), r.answers, avgLength,
+			r.promptTokens, r.completionTokens, r.cost, fmt.Sprintf("+%d/-%d", r.thumbsUp, r.thumbsDown), r.rateLimitHits)
+		for model := range r.unpricedModels {
+			fmt.Printf("    (no price for model %s; its tokens are not in the cost)\n", model)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestAssignArm(t *testing.T) {
+
+	e := experiment{Name: "test", Arms: []arm{{Name: "a", Weight: 3}, {Name: "b", Weight: 1}, {Name: "c", Weight: 0}}}
+	counts := make(map[string]int)
+
+	for i := 0; i < 4000; i++ {
+		uuid := fmt.Sprintf("visitor-%d", i)
+		a := e.assignArm(uuid)
+		testAssert(t, e.assignArm(uuid).Name == a.Name)
+		counts[a.Name]++
+	}
+
+	testAssert(t, counts["c"] == 0)
+	testAssert(t, counts["a"] > 2800 && counts["a"] < 3200)
+	testAssert(t, counts["b"] > 800 && counts["b"] < 1200)
+}
+
+func TestLatestArm(t *testing.T) {
+
+	ctx := context.Background()
+	conn := setupTestDB(t, ctx)
+
+	uuid_ := uuid.NewString()
+	_, ok := latestArm(ctx, conn, uuid_)
+	testAssert(t, !ok)
+
+	m := message{uuid: uuid_, text: "AI: Kris went to Grand Circus.", arm: "control", model: "gpt-4",
+		promptTokens: 900, completionTokens: 5}
+	insertMessage(ctx, conn, m)
+	recordAnswerUsage(ctx, conn, m, len("Kris went to Grand Circus."))
+	arm, ok := latestArm(ctx, conn, uuid_)
+	testAssert(t, ok && arm == "control")
+
+	// Feedback goes to the arm of the answers even after their messages are pruned
+	unwrap(conn.Exec(ctx, "DELETE FROM message_queue WHERE uuid = $1", uuid_))
+	arm, ok = latestArm(ctx, conn, uuid_)
+	testAssert(t, ok && arm == "control")
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var exportFormats = map[string]string{
+	"jsonl":    "application/jsonl",
+	"markdown": "text/markdown; charset=utf-8",
+	"html":     "text/html; charset=utf-8",
+}
+
+var exportExtensions = map[string]string{"jsonl": "jsonl", "markdown": "md", "html": "html"}
+
+type exportedMessage struct {
+	UUID             string    `json:"uuid"`
+	Timestamp        time.Time `json:"timestamp"`
+	Role             string    `json:"role"`
+	Text             string    `json:"text"`
+	Model            string    `json:"model,omitempty"`
+	PromptTokens     int       `json:"prompt_tokens,omitempty"`
+	CompletionTokens int       `json:"completion_tokens,omitempty"`
+}
+
+func exportMessage(m message) exportedMessage {
+	role, text := "user", strings.TrimPrefix(m.text, "USER: ")
+	if m.isAnswer() {
+		role, text = "assistant", strings.TrimPrefix(m.text, "AI: ")
+	}
+	return exportedMessage{m.uuid, m.timestamp, role, text, m.model, m.promptTokens, m.completionTokens}
+}
+
+type exportedSession struct {
+	UUID     string
+	Messages []exportedMessage
+}
+
+// exportSessions groups messages by visitor, in order of each visitor's first message
+func exportSessions(messages []message) []exportedSession {
+	var sessions []exportedSession
+	index := make(map[string]int)
+	for _, m := range messages {
+		i, ok := index[m.uuid]
+		if !ok {
+			i = len(sessions)
+			index[m.uuid] = i
+			sessions = append(sessions, exportedSession{UUID: m.uuid})
+		}
+		sessions[i].Messages = append(sessions[i].Messages, exportMessage(m))
+	}
+	return sessions
+}
+
+var exportHTML = template.Must(template.New("export").Funcs(template.FuncMap{
+	"time": func(t time.Time) string { return t.Format("2006-01-02 15:04:05") },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+  body { font-family: sans-serif; max-width: 50em; margin: 2em auto; padding: 0 1em; color: #222; }
+  .message { margin: 1em 0; padding: 0.5em 1em; border-radius: 0.5em; white-space: pre-wrap; }
+  .user { background: #e8f0fe; }
+  .assistant { background: #f1f3f4; }
+  .meta { font-size: 0.8em; color: #666; white-space: normal; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{range .Sessions}}<h2>Conversation {{.UUID}}</h2>
+{{range .Messages}}<div class="message {{.Role}}"><div class="meta">{{if eq .Role "user"}}Visitor{{else}}Chatbot{{end}}, {{time .Timestamp}}{{if .Model}}, {{.Model}}, {{.PromptTokens}} + {{.CompletionTokens}} tokens{{end}}</div>{{.Text}}</div>
+{{end}}{{end}}</body>
+</html>
+`))
+
+// writeExport renders messages in one of the exportFormats
+func writeExport(w io.Writer, format, title string, messages []message) error {
+	sessions := exportSessions(messages)
+	switch format {
+	case "jsonl":
+		encoder := json.NewEncoder(w)
+		for _, s := range sessions {
+			for _, m := range s.Messages {
+				if err := encoder.Encode(m); err != nil {
+					return err
+				}
+			}
+		}
+	case "markdown":
+		fmt.Fprintf(w, "# %s\n", title)
+		for _, s := range sessions {
+			fmt.Fprintf(w, "\n## Conversation %s\n", s.UUID)
+			for _, m := range s.Messages {
+				if m.Role == "user" {
+					fmt.Fprintf(w, "\n**Visitor** (%s)\n\n", m.Timestamp.Format("2006-01-02 15:04:05"))
+				} else {
+					fmt.Fprintf(w, "\n**Chatbot** (%s, %s, %d + %d tokens)\n\n", m.Timestamp.Format("2006-01-02 15:04:05"),
+						m.Model, m.PromptTokens, m.CompletionTokens)
+				}
+				fmt.Fprintf(w, "> %s\n", strings.ReplaceAll(m.Text, "\n", "\n> "))
+			}
+		}
+	case "html":
+		return exportHTML.Execute(w, struct {
+			Title    string
+			Sessions []exportedSession
+		}{title, sessions})
+	default:
+		return fmt.Errorf("invalid export format '%s'", format)
+	}
+	return nil
+}
+
+// exportCommand writes one visitor's conversation, or every conversation in a date range, to stdout:
+//
+//	./portfolio-chatbot export [-format jsonl|markdown|html] -uuid {uuid}
+//	./portfolio-chatbot export [-format jsonl|markdown|html] -from 2024-03-01 [-to 2024-03-08]
+func exportCommand(args []string, settings settings, ctx context.Context, conn *pgx.Conn) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "markdown", "jsonl, markdown or html")
+	uuid := flags.String("uuid", "", "export this visitor's conversation")
+	from := flags.String("from", "", "export conversations from this date (YYYY-MM-DD)")
+	to := flags.String("to", "", "export conversations until this date, exclusive (default: now)")
+	fail(flags.Parse(args))
+
+	if _, ok := exportFormats[*format]; !ok || (*uuid == "") == (*from == "") {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var messages []message
+	var title string
+	if *uuid != "" {
+		messages = sessionMessages(ctx, conn, *uuid)
+		title = "Conversation with portfolio-chatbot"
+	} else {
+		fromTime, toTime, err := parseDateRange(*from, *to)
+		if err != nil {
+			log.Fatal(err)
+		}
+		messages = messagesBetween(ctx, conn, fromTime, toTime)
+		title = fmt.Sprintf("portfolio-chatbot conversations from %s to %s", fromTime.Format("2006-01-02"),
+			toTime.Format("2006-01-02 15:04"))
+	}
+	fail(writeExport(os.Stdout, *format, title, messages))
+}
+
+func parseDateRange(from, to string) (time.Time, time.Time, error) {
+	fromTime, err := time.Parse("2006-01-02", from)
+	if err != nil {
+		return fromTime, fromTime, fmt.Errorf("invalid from date '%s' (should be YYYY-MM-DD)", from)
+	}
+	toTime := time.Now()
+	if to != "" {
+		toTime, err = time.Parse("2006-01-02", to)
+		if err != nil {
+			return fromTime, toTime, fmt.Errorf("invalid to date '%s' (should be YYYY-MM-DD)", to)
+		}
+	}
+	return fromTime, toTime, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteExport(t *testing.T) {
+
+	timestamp := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	messages := []message{
+		{uuid: "a", text: "USER: Where is Kris?", timestamp: timestamp},
+		{uuid: "b", text: "USER: <script>alert(1)</script>", timestamp: timestamp},
+		{uuid: "a", text: "AI: In Michigan.", model: "gpt-4", promptTokens: 900, completionTokens: 5, timestamp: timestamp},
+	}
+
+	var sb strings.Builder
+	fail(writeExport(&sb, "jsonl", "Test", messages))
+	var exported []exportedMessage
+	scanner := bufio.NewScanner(strings.NewReader(sb.String()))
+	for scanner.Scan() {
+		var m exportedMessage
+		fail(json.Unmarshal(scanner.Bytes(), &m))
+		exported = append(exported, m)
+	}
+	// Grouped by visitor
+	testAssert(t, len(exported) == 3 && exported[1].UUID == "a" && exported[2].UUID == "b")
+	testAssert(t, exported[1].Role == "assistant" && exported[1].Text == "In Michigan." && exported[1].PromptTokens == 900)
+
+	sb.Reset()
+	fail(writeExport(&sb, "markdown", "Test", messages))
+	testAssert(t, strings.Contains(sb.String(), "**Chatbot** (2024-03-01 12:00:00, gpt-4, 900 + 5 tokens)\n\n> In Michigan."))
+
+	sb.Reset()
+	fail(writeExport(&sb, "html", "Test", messages))
+	testAssert(t, strings.Contains(sb.String(), `<div class="message assistant">`))
+	testAssert(t, !strings.Contains(sb.String(), "<script>"))
+
+	testAssert(t, writeExport(&sb, "pdf", "Test", messages) != nil)
+}
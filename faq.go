@@ -0,0 +1,210 @@
+package main
+
+import (
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Without this file, every question goes to the model
+	faqFile = "faq.yaml"
+	// How similar a claim in an answer has to be to the knowledge without citations.yaml
+	faqMinSimilarity = 0.3
+)
+
+type intent struct {
+	ID       string   `yaml:"id"`
+	Examples []string `yaml:"examples"`
+	// Answer is a text/template, executed with faqSlots
+	Answer string `yaml:"answer"`
+}
+
+type faqConfig struct {
+	// A question is answered from the FAQ if it's at least this similar (0-1) to one of an intent's examples
+	Threshold float64  `yaml:"threshold"`
+	Intents   []intent `yaml:"intents"`
+}
+
+type faqSlots struct {
+	Question string
+	Date     string
+}
+
+// loadFAQ reads faq.yaml (see faq.example.yaml), e.g.:
+//
+//	threshold: 0.6
+//	intents:
+//	  - id: education
+//	    examples: [Where did Kris go to school?, What bootcamp did he attend?]
+//	    answer: Kris went to Grand Circus Java Bootcamp.
+func loadFAQ() *faqConfig {
+	return loadFAQFile(faqFile)
+}
+
+func loadFAQFile(path string) *faqConfig {
+	if !fileExists(path) {
+		return nil
+	}
+	var config faqConfig
+	decoder := yaml.NewDecoder(strings.NewReader(readFile(path)))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil {
+		log.Fatalf("%s: %v", path, err)
+	}
+	if config.Threshold <= 0 || config.Threshold > 1 {
+		log.Fatalf("%s: threshold should be between 0 and 1", path)
+	}
+	for _, i := range config.Intents {
+		if _, err := template.New(i.ID).Option("missingkey=error").Parse(i.Answer); err != nil || i.ID == "" ||
+			len(i.Examples) == 0 {
+			log.Fatalf("%s: Invalid intent '%s' (it needs an id, examples and a valid answer): %v", path, i.ID, err)
+		}
+	}
+	return &config
+}
+
+// trigrams returns the set of character trigrams of a text's words, which makes for a similarity that tolerates typos
+func trigrams(text string) map[string]bool {
+	grams := make(map[string]bool)
+	padded := " " + strings.Join(topicWords(text), " ") + " "
+	runes := []rune(padded)
+	for i := 0; i+3 <= len(runes); i++ {
+		grams[string(runes[i:i+3])] = true
+	}
+	return grams
+}
+
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for gram := range a {
+		if b[gram] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// faqIgnoredWords don't change what a question asks for, unlike e.g. "long" in "How long was the bootcamp?"
+var faqIgnoredWords = map[string]bool{"which": true, "he": true, "his": true, "him": true, "me": true, "my": true,
+	"you": true, "your": true, "please": true, "tell": true, "about": true}
+
+// withinOneEdit tells whether two words differ by at most one inserted, deleted or replaced letter
+func withinOneEdit(a, b string) bool {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) < len(rb) {
+		ra, rb = rb, ra
+	}
+	if len(ra)-len(rb) > 1 {
+		return false
+	}
+	i := 0
+	for i < len(rb) && ra[i] == rb[i] {
+		i++
+	}
+	if i == len(ra) {
+		return true
+	}
+	if len(ra) == len(rb) {
+		return string(ra[i+1:]) == string(rb[i+1:])
+	}
+	return string(ra[i+1:]) == string(rb[i:])
+}
+
+// sameWord tells whether two words are the same, give or take a suffix (attend, attended) or a typo (school, scool)
+func sameWord(a, b string) bool {
+	if a == b {
+		return true
+	}
+	if len(a) < 4 || len(b) < 4 {
+		return false
+	}
+	return strings.HasPrefix(a, b) || strings.HasPrefix(b, a) || withinOneEdit(a, b)
+}
+
+// covers tells whether every meaningful word of a question appears in the intent's examples. A question can be very
+// similar to an example and still ask for more than its answer says (e.g., "How long was the bootcamp Kris attended?").
+func (i intent) covers(question string) bool {
+	var vocabulary []string
+	for _, example := range i.Examples {
+		vocabulary = append(vocabulary, topicWords(example)...)
+	}
+	for _, word := range topicWords(question) {
+		if faqIgnoredWords[word] {
+			continue
+		}
+		found := false
+		for _, known := range vocabulary {
+			if sameWord(word, known) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// match finds the intent whose examples are most similar to a question, by TF-IDF (shared meaningful words) or by
+// trigrams (similar spelling), whichever is higher, among the intents that cover the question. It returns nil if no
+// intent reaches the threshold.
+func (config faqConfig) match(question string) (*intent, float64) {
+	examples := make(map[string][]string)
+	for _, i := range config.Intents {
+		examples[i.ID] = i.Examples
+	}
+	model := trainTFIDF(examples)
+	v, questionTrigrams := model.vector(question), trigrams(question)
+
+	var best *intent
+	bestSimilarity := 0.0
+	for n, i := range config.Intents {
+		if !i.covers(question) {
+			continue
+		}
+		for _, example := range i.Examples {
+			similarity := Max(cosine(v, model.vector(example)), jaccard(questionTrigrams, trigrams(example)))
+			if similarity > bestSimilarity {
+				best, bestSimilarity = &config.Intents[n], similarity
+			}
+		}
+	}
+	if bestSimilarity < config.Threshold {
+		return nil, bestSimilarity
+	}
+	return best, bestSimilarity
+}
+
+func (i intent) answer(question string) string {
+	var sb strings.Builder
+	t := template.Must(template.New(i.ID).Option("missingkey=error").Parse(i.Answer))
+	fail(t.Execute(&sb, faqSlots{question, time.Now().Format("January 2, 2006")}))
+	return sb.String()
+}
+
+// grounded leaves out the intents whose answers make claims that the knowledge doesn't support, as citations.yaml
+// would (see citations.go). FAQ answers skip the model, so nothing else checks them.
+func (config faqConfig) grounded(chunks []chunk) faqConfig {
+	citations := citationConfig{MinSimilarity: faqMinSimilarity}
+	if c := loadCitationConfig(); c != nil {
+		citations.MinSimilarity = c.MinSimilarity
+	}
+	var intents []intent
+	for _, i := range config.Intents {
+		if unsupported := citations.cite(i.answer(""), chunks).unsupported; len(unsupported) > 0 {
+			log.Warnf("%s: Leaving out intent '%s', whose answer isn't supported by the resume, facts or %s: %s",
+				faqFile, i.ID, careerFile, strings.Join(unsupported, " "))
+			continue
+		}
+		intents = append(intents, i)
+	}
+	config.Intents = intents
+	return config
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFAQMatch(t *testing.T) {
+
+	faq := loadFAQFile("faq.example.yaml")
+	testAssert(t, faq != nil)
+
+	for question, id := range map[string]string{
+		"What languages does Kris know?":  "languages",
+		"where did kris go to scool":      "education",
+		"How do I contact him?":           "contact",
+		"What's Kris's email address?":    "contact",
+		"Which bootcamp did Kris attend?": "education",
+	} {
+		i, similarity := faq.match(question)
+		if i == nil || i.ID != id {
+			t.Errorf("%q: got %v (%.2f), want %s", question, i, similarity, id)
+		}
+	}
+
+	// Questions the FAQ doesn't cover (including the ones the other tests ask) go to the model, and so do questions
+	// that ask for more than an intent's answer says
+	for _, question := range []string{"Where is Kris?", "What does Kris do?", "How old is Kris?",
+		"Email me at [EMAIL_1]", "Hi", strings.Repeat("0", 100), "How long was the bootcamp Kris attended?"} {
+		i, similarity := faq.match(question)
+		if i != nil {
+			t.Errorf("%q: got %s (%.2f)", question, i.ID, similarity)
+		}
+	}
+
+	testAssert(t, sameWord("school", "scool") && sameWord("attend", "attended") && !sameWord("long", "go"))
+
+	i := intent{ID: "date", Answer: "Today is {{.Date}}. You asked: {{.Question}}"}
+	testAssert(t, strings.HasSuffix(i.answer("What day is it?"), ". You asked: What day is it?"))
+}
+
+func TestFAQGrounded(t *testing.T) {
+
+	// Every answer is supported by the knowledge...
+	faq := loadFAQFile("faq.example.yaml")
+	chunks := append(knowledgeChunks(testResume, facts), testCareer.chunks()...)
+	testAssert(t, len(faq.grounded(chunks).Intents) == len(faq.Intents))
+
+	// ...and the ones that aren't are left out
+	faq.Intents = append(faq.Intents, intent{ID: "stack", Examples: []string{"What is Kris's tech stack?"},
+		Answer: "Kris is also comfortable with JavaScript, SQL and Bash."})
+	grounded := faq.grounded(chunks)
+	testAssert(t, len(grounded.Intents) == len(faq.Intents)-1)
+	i, _ := grounded.match("What is Kris's tech stack?")
+	testAssert(t, i == nil || i.ID != "stack")
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testJobDescription = `Backend Engineer
+
+Requirements:
+- 2+ years of Go
+- Experience with MySQL
+- Kubernetes in production
+Nice to have:
+- Kafka
+- PostgreSQL
+
+We'd love to see someone who can go the extra mile. AWS experience is a plus.`
+
+func TestExtractSkills(t *testing.T) {
+
+	skills := extractSkills(testJobDescription, &testCareer)
+	required := make(map[string]bool)
+	for _, s := range skills {
+		required[s.Skill] = s.Required
+	}
+	testAssert(t, len(skills) == 6)
+	testAssert(t, required["Go"] && required["MySQL"] && required["Kubernetes"])
+	testAssert(t, !required["Kafka"] && !required["PostgreSQL"] && !required["AWS"])
+	testAssert(t, skills[0].Skill == "Go" && skills[len(skills)-1].Skill == "AWS")
+
+	// "go the extra mile" isn't Go, and a skill that's required anywhere is required
+	testAssert(t, len(extractSkills("Willing to go the extra mile", nil)) == 0)
+	skills = extractSkills("Python preferred\nMust know Python", nil)
+	testAssert(t, len(skills) == 1 && skills[0].Required)
+}
+
+func TestOfflineFitReport(t *testing.T) {
+
+	now := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	chunks := append(knowledgeChunks("EXPERIENCE\n\nBuilt services in Go with PostgreSQL and Docker.", nil),
+		testCareer.chunks()...)
+	report := offlineFitReport(testJobDescription, chunks, &testCareer, now)
+
+	skills := func(matches []skillMatch) string {
+		var names []string
+		for _, m := range matches {
+			names = append(names, m.Skill)
+		}
+		return strings.Join(names, ",")
+	}
+	testAssert(t, skills(report.Matched) == "Go,PostgreSQL")
+	// MySQL is close to PostgreSQL, and Kubernetes to Docker
+	testAssert(t, skills(report.Partial) == "MySQL,Kubernetes")
+	testAssert(t, strings.HasPrefix(report.Partial[0].Evidence, "PostgreSQL: "))
+	testAssert(t, skills(report.Missing) == "Kafka,AWS")
+	testAssert(t, report.Matched[0].Evidence == "Built services in Go with PostgreSQL and Docker.")
+
+	testAssert(t, report.TalkingPoints[0] == "Go: 2.5 years of experience (since 2021-10)")
+	testAssert(t, strings.HasPrefix(report.TalkingPoints[1], "PostgreSQL: 2.2 years"))
+	testAssert(t, strings.HasPrefix(report.TalkingPoints[2], "MySQL: no direct experience, but PostgreSQL"))
+
+	report = offlineFitReport("Friendly team, great snacks.", chunks, nil, now)
+	testAssert(t, report.Matched != nil && len(report.Matched)+len(report.Partial)+len(report.Missing) == 0)
+}
+
+func TestModelFitReport(t *testing.T) {
+
+	ctx := context.Background()
+	config := fitConfig{Model: "model", MaxTokens: 800}
+	client := funcProvider(func(content string) string {
+		// The job description is quoted, so that it can't pass for part of the prompt
+		if !strings.Contains(content, "Go with PostgreSQL") || !strings.Contains(content, `"Go and Kafka"`) {
+			return "{}"
+		}
+		return `{"matched": [{"skill": "Go", "required": true, "evidence": "Go since 2021"}],
+			"missing": [{"skill": "Kafka"}]}`
+	})
+	report, _, err := config.modelFitReport(ctx, client, "Built services in Go with PostgreSQL.", "Go and Kafka")
+	testAssert(t, err == nil)
+	testAssert(t, len(report.Matched) == 1 && report.Matched[0].Required && report.Matched[0].Evidence != "")
+	testAssert(t, len(report.Missing) == 1 && report.Partial != nil && report.TalkingPoints != nil)
+
+	_, _, err = config.modelFitReport(ctx, funcProvider(func(string) string { return "Sure!" }), "", "")
+	testAssert(t, err != nil)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+	"gopkg.in/yaml.v3"
+)
+
+// Without this file, answers don't come with follow-up questions
+const followUpFile = "followups.yaml"
+
+// answer is what answerQuestion returns: the reply to show the visitor, and questions they might want to ask next. An
+// answer from the model also comes with its citations, and the claims that none of them supports (see citations.go).
+type answer struct {
+	Text              string     `json:"answer"`
+	FollowUps         []string   `json:"followUps,omitempty"`
+	Citations         []citation `json:"citations,omitempty"`
+	UnsupportedClaims []string   `json:"unsupportedClaims,omitempty"`
+}
+
+type followUpConfig struct {
+	Count int `yaml:"count"`
+	// With a model (through the same provider as the answers), follow-ups are written by it, in the visitor's language
+	Model string `yaml:"model"`
+	// Without a model, or if it fails, follow-ups are picked from the candidates, which are in English
+	Candidates []string `yaml:"candidates"`
+}
+
+// A suggestion at least this similar (0-1) to a question the visitor asked, or to another suggestion, would repeat it
+const followUpRepeatSimilarity = 0.6
+
+// loadFollowUpConfig reads followups.yaml, e.g.:
+//
+//	count: 3
+//	model: gpt-3.5-turbo
+//	candidates: [What projects has Kris worked on?, What languages does Kris know?]
+func loadFollowUpConfig() *followUpConfig {
+	if !fileExists(followUpFile) {
+		return nil
+	}
+	var config followUpConfig
+	decoder := yaml.NewDecoder(strings.NewReader(readFile(followUpFile)))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil {
+		log.Fatalf("%s: %v", followUpFile, err)
+	}
+	if config.Count < 1 {
+		log.Fatalf("%s: count should be positive", followUpFile)
+	}
+	return &config
+}
+
+// repeats tells whether a suggestion would repeat one of the questions
+func repeats(model tfidfModel, suggestion string, questions []string) bool {
+	v := model.vector(suggestion)
+	for _, q := range questions {
+		if normalizeQuestion(q) == normalizeQuestion(suggestion) ||
+			cosine(v, model.vector(q)) >= followUpRepeatSimilarity {
+			return true
+		}
+	}
+	return false
+}
+
+// pickFollowUps picks the candidates that are most related to the last question and its answer, leaving out the
+// ones that repeat a question the visitor asked (or another pick)
+func (config followUpConfig) pickFollowUps(asked []string, question, response string, n int) []string {
+	model := trainTFIDF(map[string][]string{"candidates": append(append([]string{}, config.Candidates...), asked...)})
+	conversation := model.vector(question + " " + response)
+	candidates := append([]string{}, config.Candidates...)
+	relevance := make(map[string]float64)
+	for _, c := range candidates {
+		relevance[c] = cosine(conversation, model.vector(c))
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return relevance[candidates[i]] > relevance[candidates[j]] })
+
+	var picks []string
+	for _, c := range candidates {
+		if len(picks) == n {
+			break
+		}
+		if !repeats(model, c, asked) && !repeats(model, c, picks) {
+			picks = append(picks, c)
+		}
+	}
+	return picks
+}
+
+const followUpPrompt = `You suggest follow-up questions for a chatbot that answers questions about the career of a software engineer named Kris Cherven. It can answer questions like these: %s
+
+Here is the conversation so far:
+
+%s
+
+Suggest %d short questions the questioner might want to ask next, about Kris's career, in %s. Don't suggest questions they have already asked. Answer with a JSON object like {"followUps": ["...", "..."]}.`
+
+// writeFollowUps asks the model for follow-up questions
+func (config followUpConfig) writeFollowUps(ctx context.Context, client provider, history []string,
+	language string) ([]string, openai.Usage, error) {
+
+	prompt := fmt.Sprintf(followUpPrompt, strings.Join(config.Candidates, " "), strings.Join(history, "\n"),
+		config.Count, languageNames[language])
+	resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model:          config.Model,
+		MaxTokens:      150,
+		ResponseFormat: &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject},
+		Messages:       []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: prompt}},
+	})
+	if err != nil {
+		return nil, resp.Usage, err
+	}
+	if len(resp.Choices) == 0 {
+		return nil, resp.Usage, fmt.Errorf("no answer from %s", config.Model)
+	}
+	var written struct {
+		FollowUps []string `json:"followUps"`
+	}
+	if err := json.Unmarshal([]byte(resp.Choices[0].Message.Content), &written); err != nil {
+		return nil, resp.Usage, err
+	}
+	return written.FollowUps, resp.Usage, nil
+}
+
+// suggestFollowUps suggests follow-up questions to the answer to the last question of a conversation (history, in
+// "USER: "/"AI: " messages, ending with the question). Without a client (or with the fake responses), they're only
+// picked from the candidates. It returns the tokens used, which count towards the answer.
+func (config *followUpConfig) suggestFollowUps(ctx context.Context, client provider, history []string,
+	question, response, language string) ([]string, openai.Usage) {
+
+	var usage openai.Usage
+	if config == nil {
+		return nil, usage
+	}
+	var asked []string
+	for _, m := range history {
+		if strings.HasPrefix(m, "USER: ") {
+			asked = append(asked, strings.TrimPrefix(m, "USER: "))
+		}
+	}
+
+	var suggestions []string
+	if config.Model != "" && client != nil {
+		conversation := append(append([]string{}, history...), "AI: "+response)
+		written, used, err := config.writeFollowUps(ctx, client, conversation, language)
+		usage = used
+		if err != nil {
+			log.Warnf("Follow-up model failed: %v", err)
+		}
+		model := trainTFIDF(map[string][]string{"asked": asked})
+		for _, s := range written {
+			if s = normalizeInput(s, false); s != "" && len(suggestions) < config.Count &&
+				!repeats(model, s, asked) && !repeats(model, s, suggestions) {
+				suggestions = append(suggestions, s)
+			}
+		}
+	}
+	// The candidates are in English
+	if len(suggestions) < config.Count && language == defaultLanguage {
+		suggestions = append(suggestions, config.pickFollowUps(append(asked, suggestions...), question, response,
+			config.Count-len(suggestions))...)
+	}
+	return suggestions, usage
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestFollowUps(t *testing.T) {
+
+	ctx := context.Background()
+	config := &followUpConfig{Count: 2, Candidates: []string{
+		"Where has Kris worked?",
+		"What programming languages does Kris know?",
+		"How much experience does Kris have with Go?",
+		"How can I contact Kris?",
+	}}
+	history := []string{"USER: What languages does Kris know?"}
+
+	// The most related candidates, but never the question the visitor asked
+	followUps, _ := config.suggestFollowUps(ctx, nil, history, "What languages does Kris know?",
+		"Kris knows Go, Java and Python.", "en")
+	testAssert(t, len(followUps) == 2 && followUps[0] == "How much experience does Kris have with Go?")
+	for _, f := range followUps {
+		testAssert(t, f != "What programming languages does Kris know?")
+	}
+
+	// Without a model, there are no suggestions in other languages
+	followUps, _ = config.suggestFollowUps(ctx, nil, history, "¿Qué lenguajes sabe Kris?", "Go y Java.", "es")
+	testAssert(t, len(followUps) == 0)
+
+	// Written suggestions that repeat a question are replaced with candidates
+	config.Model = "writer"
+	writer := funcProvider(func(content string) string {
+		testAssert(t, strings.Contains(content, "USER: What languages does Kris know?"))
+		return `{"followUps": ["What languages does Kris know?", "Does Kris have a blog?"]}`
+	})
+	followUps, _ = config.suggestFollowUps(ctx, writer, history, "What languages does Kris know?",
+		"Kris knows Go, Java and Python.", "en")
+	testAssert(t, len(followUps) == 2 && followUps[0] == "Does Kris have a blog?" &&
+		followUps[1] == "How much experience does Kris have with Go?")
+
+	// A model that doesn't answer with JSON is ignored
+	broken := funcProvider(func(string) string { return "Sure! Here are some questions:" })
+	followUps, _ = config.suggestFollowUps(ctx, broken, history, "What languages does Kris know?",
+		"Kris knows Go, Java and Python.", "en")
+	testAssert(t, len(followUps) == 2)
+
+	var none *followUpConfig
+	followUps, _ = none.suggestFollowUps(ctx, nil, history, "What languages does Kris know?", "Go.", "en")
+	testAssert(t, followUps == nil)
+}
@@ -8,6 +8,8 @@ require (
 	github.com/sashabaranov/go-openai v1.20.2
 	github.com/sirupsen/logrus v1.9.3
 	golang.org/x/exp v0.0.0-20240222234643-814bf88cf225
+	golang.org/x/text v0.14.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -16,5 +18,4 @@ require (
 	github.com/stretchr/testify v1.8.3 // indirect
 	golang.org/x/crypto v0.21.0 // indirect
 	golang.org/x/sys v0.18.0 // indirect
-	golang.org/x/text v0.14.0 // indirect
 )
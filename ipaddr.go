@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// A subnet is shared by everyone behind the same NAT (e.g., an office), so its rate limit is this many times higher
+const subnetRateLimitFactor = 10
+
+// parseTrustedProxies parses a comma-separated list of IP addresses and CIDR ranges
+func parseTrustedProxies(list string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(s); err == nil {
+			proxies = append(proxies, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(s); err == nil {
+			proxies = append(proxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+		} else {
+			return nil, fmt.Errorf("invalid trusted proxy '%s'", s)
+		}
+	}
+	return proxies, nil
+}
+
+func isTrusted(addr netip.Addr, proxies []netip.Prefix) bool {
+	for _, proxy := range proxies {
+		if proxy.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address a request came from. X-Forwarded-For is only believed as far back as it was written by
+// trusted proxies: the client is the last address in the chain that isn't a trusted proxy.
+func clientIP(r *http.Request, proxies []netip.Prefix) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr = addr.Unmap()
+
+	var forwarded []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0 && isTrusted(addr, proxies); i-- {
+		next, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+		addr = next.Unmap()
+	}
+	return addr, true
+}
+
+// ipHashes are keyed hashes of an IP address and of its subnet (/24 for IPv4, /64 for IPv6). The salt changes every
+// day, so that the hashes can't be linked across days, nor reversed by hashing every possible address.
+type ipHashes struct {
+	addr   string
+	subnet string
+}
+
+func hashIP(salt []byte, addr netip.Addr) ipHashes {
+	bits := 24
+	if addr.Is6() {
+		bits = 64
+	}
+	subnet := netip.PrefixFrom(addr, bits).Masked()
+	hash := func(s string) string {
+		mac := hmac.New(sha256.New, salt)
+		mac.Write([]byte(s))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	return ipHashes{hash(addr.String()), hash(subnet.String())}
+}
+
+// subnetRateLimited applies the (coarser) rate limit of a subnet, and counts the question towards it
+func subnetRateLimited(ctx context.Context, conn *pgx.Conn, settings settings, subnetHash,
+	language string) (string, bool) {
+	count := settings.rateLimitCount * subnetRateLimitFactor
+	if timeElapsed, limited := rateLimitElapsed(ctx, conn, []string{subnetHash}, count,
+		settings.rateLimitDelay); limited {
+		return rateLimitMessage(language, Ceil((float64(settings.rateLimitDelay)-float64(timeElapsed))/1000.0)), true
+	}
+	resetExpiredRateLimit(ctx, conn, subnetHash, settings.rateLimitDelay)
+	incrementRateLimit(ctx, conn, subnetHash)
+	return "", false
+}
+
+// requestIPHashes hashes the client IP of a request with today's salt, and links the hashes to the keys that bans on
+// them are stored under (see linkBanKeys). It must be called with s.mu held.
+func (s *server) requestIPHashes(r *http.Request) (ipHashes, bool) {
+	addr, ok := clientIP(r, s.trustedProxies)
+	if !ok {
+		return ipHashes{}, false
+	}
+	now := time.Now()
+	hashes, banKeys := hashIP(ipSalt(s.ctx, s.conn, now), addr), hashIP(banSalt(s.ctx, s.conn), addr)
+	linkBanKeys(s.ctx, s.conn, now, map[string]string{hashes.addr: banKeys.addr, hashes.subnet: banKeys.subnet})
+	return hashes, true
+}
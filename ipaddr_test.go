@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+
+	proxies, err := parseTrustedProxies("127.0.0.1, 10.0.0.0/8")
+	testAssert(t, err == nil && len(proxies) == 2)
+	_, err = parseTrustedProxies("localhost")
+	testAssert(t, err != nil)
+
+	clientIPOf := func(remoteAddr string, forwardedFor ...string) string {
+		r := httptest.NewRequest("POST", "/question", nil)
+		r.RemoteAddr = remoteAddr
+		for _, header := range forwardedFor {
+			r.Header.Add("X-Forwarded-For", header)
+		}
+		addr, ok := clientIP(r, proxies)
+		testAssert(t, ok)
+		return addr.String()
+	}
+
+	testAssert(t, clientIPOf("203.0.113.7:5000") == "203.0.113.7")
+	// Only trusted proxies get to say who the client is...
+	testAssert(t, clientIPOf("203.0.113.7:5000", "198.51.100.1") == "203.0.113.7")
+	testAssert(t, clientIPOf("127.0.0.1:5000", "198.51.100.1") == "198.51.100.1")
+	testAssert(t, clientIPOf("127.0.0.1:5000", "198.51.100.1, 10.1.2.3") == "198.51.100.1")
+	testAssert(t, clientIPOf("127.0.0.1:5000", "198.51.100.1", "10.1.2.3") == "198.51.100.1")
+	// ...so whatever the client made up before that is ignored
+	testAssert(t, clientIPOf("127.0.0.1:5000", "192.0.2.66, 198.51.100.1") == "198.51.100.1")
+	testAssert(t, clientIPOf("[::ffff:127.0.0.1]:5000", "2001:db8::1") == "2001:db8::1")
+}
+
+func TestHashIP(t *testing.T) {
+
+	salt, otherSalt := randomSecret(), randomSecret()
+	hash := func(salt []byte, s string) ipHashes {
+		return hashIP(salt, netip.MustParseAddr(s))
+	}
+
+	a, b, c := hash(salt, "198.51.100.1"), hash(salt, "198.51.100.200"), hash(salt, "198.51.101.1")
+	testAssert(t, a.addr != b.addr && a.subnet == b.subnet && a.subnet != c.subnet)
+	testAssert(t, a == hash(salt, "198.51.100.1") && a.addr != hash(otherSalt, "198.51.100.1").addr)
+
+	v6, sameSubnet := hash(salt, "2001:db8:0:1::1"), hash(salt, "2001:db8:0:1:ffff::1")
+	testAssert(t, v6.addr != sameSubnet.addr && v6.subnet == sameSubnet.subnet)
+	testAssert(t, v6.subnet != hash(salt, "2001:db8:0:2::1").subnet)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+	"gopkg.in/yaml.v3"
+)
+
+const defaultLanguage = "en"
+
+// languageNames are the languages detectLanguage knows, by ISO 639-1 code, with the names the prompt uses
+var languageNames = map[string]string{
+	"ar": "Arabic", "de": "German", "el": "Greek", "en": "English", "es": "Spanish", "fr": "French", "he": "Hebrew",
+	"hi": "Hindi", "it": "Italian", "ja": "Japanese", "ko": "Korean", "nl": "Dutch", "pt": "Portuguese",
+	"ru": "Russian", "th": "Thai", "uk": "Ukrainian", "zh": "Chinese",
+}
+
+// Languages with their own script are told apart by it (Japanese also uses Han, so kana decide between the two)
+var languageScripts = []struct {
+	language string
+	script   *unicode.RangeTable
+}{
+	{"ja", unicode.Hiragana}, {"ja", unicode.Katakana}, {"ko", unicode.Hangul}, {"zh", unicode.Han},
+	{"ru", unicode.Cyrillic}, {"ar", unicode.Arabic}, {"el", unicode.Greek}, {"he", unicode.Hebrew},
+	{"hi", unicode.Devanagari}, {"th", unicode.Thai},
+}
+
+// Languages in the Latin script are told apart by their most common words
+var languageWords = map[string][]string{
+	"en": {"the", "is", "are", "was", "what", "where", "when", "who", "how", "does", "did", "do", "has", "have", "he",
+		"his", "in", "of", "and", "to", "with", "you", "can", "about", "which", "why", "any"},
+	"es": {"el", "la", "los", "las", "es", "qué", "que", "dónde", "cuándo", "quién", "cómo", "tiene", "de", "del", "y",
+		"en", "con", "su", "sus", "por", "para", "puede", "sobre", "cuál", "cuántos", "años", "trabaja"},
+	"fr": {"le", "la", "les", "est", "où", "quand", "qui", "comment", "quel", "quelle", "quels", "a", "de", "des", "du",
+		"et", "en", "avec", "il", "son", "sa", "ses", "peut", "sur", "ans", "travaille", "est-ce", "qu'est-ce"},
+	"de": {"der", "die", "das", "ist", "was", "wo", "wann", "wer", "wie", "hat", "und", "in", "mit", "er", "sein",
+		"seine", "kann", "über", "welche", "welcher", "jahre", "arbeitet", "für", "von", "bei"},
+	"pt": {"o", "os", "as", "é", "onde", "quando", "quem", "como", "qual", "tem", "de", "do", "da", "e", "em", "com",
+		"ele", "seu", "sua", "pode", "sobre", "anos", "trabalha", "para", "que"},
+	"it": {"il", "lo", "gli", "è", "dove", "quando", "chi", "come", "quale", "ha", "di", "del", "della", "e", "in",
+		"con", "lui", "suo", "sua", "può", "su", "anni", "lavora", "per", "che", "cosa"},
+	"nl": {"de", "het", "een", "is", "wat", "waar", "wanneer", "wie", "hoe", "heeft", "van", "en", "in", "met", "hij",
+		"zijn", "kan", "over", "welke", "jaar", "werkt", "voor", "bij"},
+}
+
+// detectLanguage guesses the language of a text. It returns "" when it can't tell, e.g., for "Hi" or "Go?", which is
+// most of the time for short texts in the Latin script.
+func detectLanguage(text string) string {
+	letters, latin := 0, 0
+	scripts := make(map[string]int)
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if unicode.Is(unicode.Latin, r) {
+			latin++
+			continue
+		}
+		for _, s := range languageScripts {
+			if unicode.Is(s.script, r) {
+				scripts[s.language]++
+				break
+			}
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+	if latin*2 < letters {
+		switch {
+		case scripts["ja"] > 0:
+			return "ja"
+		case scripts["ru"] > 0 && strings.ContainsAny(text, "іїєґІЇЄҐ"):
+			return "uk"
+		}
+		best := ""
+		for language, n := range scripts {
+			if best == "" || n > scripts[best] || (n == scripts[best] && language < best) {
+				best = language
+			}
+		}
+		return best
+	}
+
+	scores := make(map[string]int)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\'' && r != '-'
+	}) {
+		for language, words := range languageWords {
+			for _, w := range words {
+				if word == w {
+					scores[language]++
+					break
+				}
+			}
+		}
+	}
+	best, second := "", 0
+	for language, score := range scores {
+		if best == "" || score > scores[best] || (score == scores[best] && language < best) {
+			best = language
+		}
+	}
+	for language, score := range scores {
+		if language != best {
+			second = Max(second, score)
+		}
+	}
+	// Too few common words, or a tie, isn't enough to go on
+	if best == "" || scores[best] < 2 || scores[best] == second {
+		return ""
+	}
+	return best
+}
+
+// visitorLanguage decides which language to answer a visitor in. A language the frontend asks for wins; otherwise,
+// it's the language of the question, if detectLanguage can tell, or else the language of the visitor's earlier
+// questions. Either way, it's remembered for the rest of the session.
+func visitorLanguage(ctx context.Context, conn *pgx.Conn, uuid, question, override string) string {
+	language := override
+	if _, ok := languageNames[language]; !ok {
+		language = detectLanguage(question)
+	}
+	if language == "" {
+		if language = sessionLanguage(ctx, conn, uuid); language == "" {
+			language = defaultLanguage
+		}
+		return language
+	}
+	setSessionLanguage(ctx, conn, uuid, language)
+	return language
+}
+
+const localeDir = "locales"
+
+// loadCatalog reads locales/{language}.yaml, which maps canned replies (and the format strings that make them) to
+// their translations, e.g.:
+//
+//	"Please ask me a question.": "Hazme una pregunta, por favor."
+//
+// Replies missing from a catalog, including guard replies that were changed in guards.yaml, stay in English.
+func loadCatalog(language string) map[string]string {
+	path := filepath.Join(localeDir, language+".yaml")
+	catalog := make(map[string]string)
+	if language == defaultLanguage || !fileExists(path) {
+		return catalog
+	}
+	decoder := yaml.NewDecoder(strings.NewReader(readFile(path)))
+	if err := decoder.Decode(&catalog); err != nil {
+		log.Fatalf("%s: %v", path, err)
+	}
+	return catalog
+}
+
+// localize translates a canned reply into a language, with any fmt arguments
+func localize(language, format string, args ...any) string {
+	if translation, ok := loadCatalog(language)[format]; ok {
+		format = translation
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestDetectLanguage(t *testing.T) {
+
+	for question, language := range map[string]string{
+		"Where did Kris go to school?":             "en",
+		"¿Dónde trabaja Kris y qué lenguajes usa?": "es",
+		"Où est-ce que Kris travaille ?":           "fr",
+		"Wo arbeitet Kris und was macht er?":       "de",
+		"Onde o Kris trabalha?":                    "pt",
+		"Dove lavora Kris e che cosa fa?":          "it",
+		"Где работает Крис?":                       "ru",
+		"Де працює Кріс? Які мови він знає?":       "uk",
+		"Kris在哪里工作？":                               "zh",
+		"Krisはどこで働いていますか？":                         "ja",
+		"크리스는 어디에서 일하나요?":                          "ko",
+		"Hi":    "",
+		"Go?":   "",
+		"12345": "",
+	} {
+		testAssert(t, detectLanguage(question) == language)
+	}
+}
+
+func TestLocalize(t *testing.T) {
+
+	testAssert(t, localize("en", "Please ask me a question.") == "Please ask me a question.")
+	testAssert(t, localize("es", "Please ask me a question.") == "Hazme una pregunta, por favor.")
+	testAssert(t, rateLimitMessage("de", 5) ==
+		"Entschuldigung, aber bitte warte noch 5 Sekunden, bevor du eine weitere Nachricht sendest.")
+	// Replies without a translation stay in English
+	testAssert(t, localize("ko", "Sorry, I only talk about Kris.") == "Sorry, I only talk about Kris.")
+
+	// Translations must keep the replies' fmt verbs
+	paths, _ := filepath.Glob(filepath.Join(localeDir, "*.yaml"))
+	testAssert(t, len(paths) > 0)
+	for _, path := range paths {
+		language := strings.TrimSuffix(filepath.Base(path), ".yaml")
+		_, known := languageNames[language]
+		testAssert(t, known)
+		for english, translation := range loadCatalog(language) {
+			testAssert(t, strings.Count(english, "%d") == strings.Count(translation, "%d"))
+		}
+	}
+
+	// Every detected language has a translation of every reply
+	replies := loadCatalog("es")
+	for language := range languageNames {
+		if language == defaultLanguage {
+			continue
+		}
+		catalog := loadCatalog(language)
+		testAssert(t, len(catalog) == len(replies))
+		for english := range replies {
+			_, ok := catalog[english]
+			testAssert(t, ok)
+		}
+	}
+}
+
+func TestVisitorLanguage(t *testing.T) {
+
+	ctx := context.Background()
+	conn := setupTestDB(t, ctx)
+	stubResume(t, testResume)
+
+	uuid_ := uuid.NewString()
+	testAssert(t, visitorLanguage(ctx, conn, uuid_, "¿Dónde trabaja Kris y qué lenguajes usa?", "") == "es")
+	testAssert(t, visitorLanguage(ctx, conn, uuid_, "Hi", "") == "es")
+	testAssert(t, visitorLanguage(ctx, conn, uuid_, "Hi", "de") == "de")
+
+	// A language the caller already worked out is used as-is, rather than worked out (and remembered) again
+	answerQuestion(uuid_, uuid.NewString(), "¿Dónde trabaja Kris y qué lenguajes usa?", "fr", getSettings(), ctx, conn,
+		nil, __debugModeOff)
+	testAssert(t, sessionLanguage(ctx, conn, uuid_) == "de")
+}
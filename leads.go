@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	consentUnknown   = "unknown"
+	consentGiven     = "given"
+	consentWithdrawn = "withdrawn"
+)
+
+// Company names are only picked up when the visitor introduces themselves ("I'm a recruiter at Acme") or uses a legal
+// suffix ("Acme Corp"), since "at Grand Circus" is just as likely to be part of a question about Kris
+var companyPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i:\b(?:i work|i'm|i am|we're|we are|recruit(?:er|ing)|hiring(?: manager)?|reaching out|writing)\b` +
+		`[^.?!,]{0,30}?\b(?:at|from|with|for))\s+((?:[A-Z][\w&'-]*)(?:\s+[A-Z][\w&'-]*){0,3})`),
+	regexp.MustCompile(`\b([A-Z][\w&'-]*(?:\s+[A-Z][\w&'-]*){0,3},?\s+(?:Inc|LLC|Ltd|Corp|Corporation|GmbH))\b`),
+}
+
+// A visitor who asks to be contacted has consented to it
+var consentPattern = regexp.MustCompile(`(?i)\b(?:e-?mail|contact|reach(?: out to)?|call|text|message|ping)\s+me\b`)
+
+func detectCompanies(question string) []string {
+	var companies []string
+	seen := make(map[string]bool)
+	for _, p := range companyPatterns {
+		for _, match := range p.FindAllStringSubmatch(question, -1) {
+			company := strings.TrimSpace(match[1])
+			if !seen[company] {
+				seen[company] = true
+				companies = append(companies, company)
+			}
+		}
+	}
+	return companies
+}
+
+// detectLeads finds the contact details and company names in a question
+func detectLeads(question string) []contactDetail {
+	details := detectContact(question)
+	for _, company := range detectCompanies(question) {
+		details = append(details, contactDetail{"company", company})
+	}
+	return details
+}
+
+// storeLeads saves the leads in a (raw) question. A lead that is seen again keeps its id, but its consent can go from
+// unknown to given. With an encryption key, values are stored sealed; without one, values of a kind that is redacted
+// aren't stored at all, since they'd otherwise end up in the database in plaintext after all. It returns the leads
+// that were stored.
+func storeLeads(ctx context.Context, conn *pgx.Conn, uuid, question string) []contactDetail {
+	consent := consentUnknown
+	if consentPattern.MatchString(question) {
+		consent = consentGiven
+	}
+	key := masterKey()
+	redacted := make(map[string]bool)
+	for _, r := range loadRedactors() {
+		redacted[r.kind] = true
+	}
+	var stored []contactDetail
+	for _, lead := range detectLeads(question) {
+		if key == nil && redacted[lead.kind] {
+			log.Warnf("Not storing a %s lead: it's redacted, and there is no encryption key (see crypto.go)", lead.kind)
+			continue
+		}
+		unwrap(conn.Exec(ctx, `INSERT INTO leads (uuid, kind, value, value_hash, consent) VALUES ($1, $2, $3, $4, $5)
+													 ON CONFLICT (uuid, kind, value_hash)
+													 DO UPDATE SET consent = $5
+													 WHERE leads.consent = 'unknown'`,
+			uuid, lead.kind, sealText(key, lead.value), blindIndex(key, lead.value), consent))
+		stored = append(stored, lead)
+	}
+	return stored
+}
+
+func leadsExport(args []string, ctx context.Context, conn *pgx.Conn) {
+	flags := flag.NewFlagSet("leads export", flag.ExitOnError)
+	since := flags.Duration("since", 0, "only export leads this recent (default: all)")
+	withdrawn := flags.Bool("withdrawn", false, "also export leads whose consent was withdrawn")
+	fail(flags.Parse(args))
+
+	cutoff := time.Time{}
+	if *since > 0 {
+		cutoff = time.Now().Add(-*since)
+	}
+
+	rows := unwrap(conn.Query(ctx, `SELECT uuid, kind, value, consent, timestamp_
+																	FROM leads
+																	WHERE timestamp_ >= $1
+																	AND (consent != $2 OR $3)
+																	ORDER BY timestamp_ ASC`, cutoff, consentWithdrawn, *withdrawn))
+	defer finishRows(rows)
+
+	key := masterKey()
+	w := csv.NewWriter(os.Stdout)
+	fail(w.Write([]string{"uuid", "kind", "value", "consent", "timestamp"}))
+	for rows.Next() {
+		var uuid, kind, value, consent string
+		var timestamp time.Time
+		fail(rows.Scan(&uuid, &kind, &value, &consent, &timestamp))
+		value = unwrap(openText(value, key))
+		fail(w.Write([]string{uuid, kind, value, consent, timestamp.Format(time.RFC3339)}))
+	}
+	w.Flush()
+	fail(w.Error())
+}
+
+// leadsCommand exports leads as CSV, or records a visitor's consent (e.g., from a checkbox in the frontend):
+//
+//	./portfolio-chatbot leads export [-since 24h] [-withdrawn]
+//	./portfolio-chatbot leads consent {uuid} {given|withdrawn}
+func leadsCommand(args []string, settings settings, ctx context.Context, conn *pgx.Conn) {
+	if len(args) >= 1 && args[0] == "export" {
+		leadsExport(args[1:], ctx, conn)
+	} else if len(args) == 3 && args[0] == "consent" && (args[2] == consentGiven || args[2] == consentWithdrawn) {
+		tag := unwrap(conn.Exec(ctx, "UPDATE leads SET consent = $1 WHERE uuid = $2", args[2], args[1]))
+		fmt.Printf("Updated %d leads\n", tag.RowsAffected())
+	} else {
+		fmt.Println("Error: Wrong format: Should be ./portfolio-chatbot leads export [-since 24h] [-withdrawn], " +
+			"or ./portfolio-chatbot leads consent {uuid} {given|withdrawn}.")
+		os.Exit(2)
+	}
+}
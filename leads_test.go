@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDetectLeads(t *testing.T) {
+
+	has := func(details []contactDetail, kind, value string) bool {
+		for _, detail := range details {
+			if detail.kind == kind && detail.value == value {
+				return true
+			}
+		}
+		return false
+	}
+
+	leads := detectLeads("Hi, I'm a recruiter at Initech. Email me at jane.doe@initech.com or call +1 555-123-4567. " +
+		"My profile is https://www.linkedin.com/in/jane-doe/")
+	testAssert(t, len(leads) == 4)
+	testAssert(t, has(leads, "company", "Initech"))
+	testAssert(t, has(leads, "email", "jane.doe@initech.com"))
+	testAssert(t, has(leads, "phone", "+1 555-123-4567"))
+	testAssert(t, has(leads, "linkedin", "https://www.linkedin.com/in/jane-doe/"))
+
+	testAssert(t, has(detectLeads("Would Kris relocate for Globex Corp?"), "company", "Globex Corp"))
+
+	// Questions about Kris's own history are not leads
+	testAssert(t, len(detectLeads("What did Kris learn at Grand Circus in 2022?")) == 0)
+	testAssert(t, len(detectLeads("Is Kris 24 years old?")) == 0)
+
+	testAssert(t, consentPattern.MatchString("Please email me the details"))
+	testAssert(t, !consentPattern.MatchString("What is Kris's email?"))
+}
@@ -14,6 +14,7 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
@@ -39,19 +40,6 @@ const (
 )
 
 const (
-	instructions1 = `You are an assistant who answers career-related questions about a software engineer named Kris Cherven. The following
-is information about his career. In this information, there is a 'facts section' and a 'resume section'. Information in the facts section
-takes priority over information in the resume section. The resume section starts after the text BEGINNING OF RESUME SECTION and ends at the
-text END OF RESUME SECTION. The facts section starts after the text BEGINNING OF FACTS SECTION and ends at the text END OF FACTS SECTION.
-When answering questions about the school Kris Cherven went to, talk about Grand Circus Java Bootcamp. Do not mention the 'facts section'
-or the 'resume section', or "the information provided" or any other meta-information provided in this paragraph when answering questions.
-The information about Kris Cherven is as follows:`
-
-	instructions2 = `Please answer the last of the following questions about Kris Cherven, using the preceding chat history as context.
-In the chat history, you are "AI" and the questioner is "USER". However, new messages should never be prefixed with "AI:". Also remember
-that you only have about 10 KB of chat history. Please try to answer the question briefly. If you do not understand the question, or if
-the question is not a valid English question, please ask the questioner to clarify what they are asking:`
-
 	debugMode = debugModeSimple
 )
 
@@ -69,12 +57,12 @@ var (
 	GCTimeThreshold       = 7200 * 1000
 )
 
-func rateLimitMessage(timeRemaining int) string {
+func rateLimitMessage(language string, timeRemaining int) string {
 	timeRemaining = Max(1, timeRemaining)
 	if timeRemaining == 1 {
-		return fmt.Sprintf("Sorry, but please wait %d more second before sending another message.", timeRemaining)
+		return localize(language, "Sorry, but please wait %d more second before sending another message.", timeRemaining)
 	} else {
-		return fmt.Sprintf("Sorry, but please wait %d more seconds before sending another message.", timeRemaining)
+		return localize(language, "Sorry, but please wait %d more seconds before sending another message.", timeRemaining)
 	}
 }
 
@@ -88,25 +76,21 @@ func initializeClient() *openai.Client {
 	return openai.NewClient(apiKey)
 }
 
-func information() string {
-	outFile := "instructions.txt"
-	{
-		if fileExists("resume.pdf") {
-			fail(exec.Command("pdftotext", "resume.pdf").Run())
-			// Use the resume.pdf from the parent project (portfolio-webpage)
-		} else if fileExists("../portfolio-webpage-untracked/resume.pdf") {
-			fail(exec.Command("pdftotext", "../portfolio-webpage-untracked/resume.pdf").Run())
-			fail(exec.Command("mv", "../portfolio-webpage-untracked/resume.txt", "resume.txt").Run())
-		} else {
-			log.Fatal("resume.pdf does not exist; aborting")
-		}
-		fail(exec.Command("mv", "resume.txt", outFile).Run())
-		text := readFile(outFile)
-		text = strings.Replace(instructions1, "\n", " ", -1) + "\n\nBEGINNING OF RESUME SECTION\n\n" + text + "\n\nEND OF RESUME SECTION\n\n"
-		text = text + "BEGINNING OF FACTS SECTION\n\n" + strings.Join(facts, "\n") + "\n\nEND OF FACTS SECTION\n\n" + instructions2 + "\n"
-		os.WriteFile(outFile, []byte(text), 0644)
+// resume converts resume.pdf to text (resume.txt) and returns the text
+// resume is a variable so that tests can do without resume.pdf
+var resume = readResume
+
+func readResume() string {
+	if fileExists("resume.pdf") {
+		fail(exec.Command("pdftotext", "resume.pdf").Run())
+		// Use the resume.pdf from the parent project (portfolio-webpage)
+	} else if fileExists("../portfolio-webpage-untracked/resume.pdf") {
+		fail(exec.Command("pdftotext", "../portfolio-webpage-untracked/resume.pdf").Run())
+		fail(exec.Command("mv", "../portfolio-webpage-untracked/resume.txt", "resume.txt").Run())
+	} else {
+		log.Fatal("resume.pdf does not exist; aborting")
 	}
-	return readFile(outFile)
+	return readFile("resume.txt")
 }
 
 type WIPsettings struct {
@@ -115,6 +99,8 @@ type WIPsettings struct {
 	maxQuestionLength Maybe_t[int]
 	rateLimitCount    Maybe_t[int]
 	rateLimitDelay    Maybe_t[int]
+	promptVersion     Maybe_t[string]
+	mapConfusables    Maybe_t[bool]
 }
 
 type settings struct {
@@ -123,6 +109,21 @@ type settings struct {
 	maxQuestionLength int
 	rateLimitCount    int
 	rateLimitDelay    int
+	promptVersion     string
+	mapConfusables    bool
+}
+
+// entries returns every setting as it would be written in a settings file
+func (s settings) entries() [][2]string {
+	return [][2]string{
+		{"chatbot-enabled", fmt.Sprint(s.chatbotEnabled)},
+		{"false-response", fmt.Sprint(s.falseResponse)},
+		{"max-question-length", fmt.Sprint(s.maxQuestionLength)},
+		{"rate-limit-count", fmt.Sprint(s.rateLimitCount)},
+		{"rate-limit-delay", fmt.Sprint(s.rateLimitDelay)},
+		{"prompt-version", s.promptVersion},
+		{"map-confusables", fmt.Sprint(s.mapConfusables)},
+	}
 }
 
 func getSettings() settings {
@@ -189,6 +190,17 @@ func getSettings() settings {
 				} else {
 					log.Fatalf("%s: Setting '%s' has invalid val '%v'", fileName, setting, val)
 				}
+			case "prompt-version":
+				promptTemplate(val) // fails if the prompt doesn't exist or is invalid
+				settings_.promptVersion = Maybe(val)
+			case "map-confusables":
+				if val == "true" || val == "false" {
+					b, err := strconv.ParseBool(val)
+					settings_.mapConfusables = Maybe(b)
+					fail(err)
+				} else {
+					log.Fatalf("%s: Setting '%s' has invalid val '%v'", fileName, setting, val)
+				}
 			default:
 				log.Errorf("%s: Found setting '%s' with val '%v', but it's not a valid setting.", fileName, setting, val)
 			}
@@ -209,6 +221,12 @@ func getSettings() settings {
 			if !settings_.rateLimitDelay.ok {
 				log.Fatalf("%s: Missing setting: rate-limit-delay", fileName)
 			}
+			if !settings_.promptVersion.ok {
+				log.Fatalf("%s: Missing setting: prompt-version", fileName)
+			}
+			if !settings_.mapConfusables.ok {
+				log.Fatalf("%s: Missing setting: map-confusables", fileName)
+			}
 		} else {
 			if !settings_.chatbotEnabled.ok {
 				settings_.chatbotEnabled = Maybe(oldSettings.chatbotEnabled)
@@ -225,6 +243,12 @@ func getSettings() settings {
 			if !settings_.rateLimitDelay.ok {
 				settings_.rateLimitDelay = Maybe(oldSettings.rateLimitDelay)
 			}
+			if !settings_.promptVersion.ok {
+				settings_.promptVersion = Maybe(oldSettings.promptVersion)
+			}
+			if !settings_.mapConfusables.ok {
+				settings_.mapConfusables = Maybe(oldSettings.mapConfusables)
+			}
 		}
 		return settings{
 			settings_.chatbotEnabled.v,
@@ -232,6 +256,8 @@ func getSettings() settings {
 			settings_.maxQuestionLength.v,
 			settings_.rateLimitCount.v,
 			settings_.rateLimitDelay.v,
+			settings_.promptVersion.v,
+			settings_.mapConfusables.v,
 		}
 	}
 
@@ -258,60 +284,60 @@ func debugln(yes bool, x ...interface{}) {
 	}
 }
 
-func answerQuestion(uuid string, ipAddrHash string, question string, settings settings, ctx context.Context,
-	conn *pgx.Conn, client *openai.Client, debugMode debugMode_t) string {
+// answerQuestion answers a question in language, an ISO 639-1 code (see languageNames) that the caller already got
+// from visitorLanguage, or works out the visitor's language itself if language is ""
+func answerQuestion(uuid string, ipAddrHash string, question string, language string, settings settings,
+	ctx context.Context, conn *pgx.Conn, client provider, debugMode debugMode_t) answer {
 
-	if settings.chatbotEnabled == false {
-		return "Sorry, but I cannot answer your question at the moment. Please try again later."
+	question = normalizeInput(question, settings.mapConfusables)
+	if language == "" {
+		language = visitorLanguage(ctx, conn, uuid, question, "")
 	}
 
-	// Only relevant when portfolio-chatbot is run interactively; It's impossible to send empty messages via the frontend
-	if len(strings.Trim(question, " \t\n\r\v\f")) == 0 {
-		return "Please ask me a question."
+	if settings.chatbotEnabled == false {
+		return answer{Text: localize(language,
+			"Sorry, but I cannot answer your question at the moment. Please try again later.")}
 	}
 
-	if len(question) > settings.maxQuestionLength {
-		return fmt.Sprintf("You question is too long (>%d characters). Please condense it and try again.",
-			settings.maxQuestionLength)
+	if _, banned := activeBan(ctx, conn, uuid, ipAddrHash); banned {
+		return answer{Text: localize(language, bannedMessage)}
 	}
 
-	exec := func(query string, args ...any) {
-		unwrap(conn.Exec(ctx, query, args...))
+	// Only relevant when portfolio-chatbot is run interactively; It's impossible to send empty messages via the frontend
+	if question == "" {
+		return answer{Text: localize(language, "Please ask me a question.")}
 	}
 
-	query := func(query string, args ...any) pgx.Rows {
-		return unwrap(conn.Query(ctx, query, args...))
+	// Counted in runes, so that questions in non-Latin scripts get as many characters as English ones
+	if length := utf8.RuneCountInString(question); length > settings.maxQuestionLength {
+		return answer{Text: localize(language, "Your question is too long (%d characters; the limit is %d). Please "+
+			"condense it and try again.", length, settings.maxQuestionLength)}
 	}
 
-	rows := query(`SELECT (EXTRACT(EPOCH FROM (current_timestamp - timestamp_)) * 1000)::INT
-								 FROM ratelimit
-								 WHERE (key = $1 OR key = $2)
-								 AND count >= $3
-								 AND EXTRACT(EPOCH FROM (current_timestamp - timestamp_))*1000 < $4`,
-		uuid, ipAddrHash, settings.rateLimitCount, settings.rateLimitDelay)
+	arm := loadExperiment(settings).assignArm(uuid)
 
-	defer finishRows(rows)
-
-	if rows.Next() {
-		var timeElapsed int
-		fail(rows.Scan(&timeElapsed))
-		return rateLimitMessage(Ceil((float64(settings.rateLimitDelay) - float64(timeElapsed)) / 1000.0))
+	if timeElapsed, limited := rateLimitElapsed(ctx, conn, []string{uuid, ipAddrHash}, settings.rateLimitCount,
+		settings.rateLimitDelay); limited {
+		recordRateLimitHit(ctx, conn, uuid, arm.Name)
+		if reportAbuse(ctx, conn, "rate-limit", uuid, ipAddrHash) {
+			return answer{Text: localize(language, bannedMessage)}
+		}
+		timeRemaining := Ceil((float64(settings.rateLimitDelay) - float64(timeElapsed)) / 1000.0)
+		return answer{Text: rateLimitMessage(language, timeRemaining)}
 	}
 
 	for _, key := range []string{uuid, ipAddrHash} {
-		rows = query(`SELECT key
-									FROM ratelimit
-									WHERE key = $1
-									AND count > 1
-									AND EXTRACT(EPOCH FROM (current_timestamp - timestamp_))*1000 >= $2`,
-			key, settings.rateLimitDelay)
-
-		if rows.Next() {
-			finishRows(rows)
-			exec("UPDATE ratelimit SET count = 0 WHERE key = $1", key)
-		} else {
-			finishRows(rows)
-		}
+		resetExpiredRateLimit(ctx, conn, key, settings.rateLimitDelay)
+	}
+
+	// Every question counts towards the rate limit, including the ones the guards stop (which may have cost a
+	// classifier call)
+	if rateLimitTestMode == rateLimitByUUID || rateLimitTestMode == rateLimitByUUIDAndIpAddrHash {
+		incrementRateLimit(ctx, conn, uuid)
+	}
+
+	if rateLimitTestMode == rateLimitByIpAddrHash || rateLimitTestMode == rateLimitByUUIDAndIpAddrHash {
+		incrementRateLimit(ctx, conn, ipAddrHash)
 	}
 
 	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
@@ -322,52 +348,66 @@ func answerQuestion(uuid string, ipAddrHash string, question string, settings se
 
 	if rnum < 1 {
 		debugln(debugMode >= debugModeSimple, "Running random GC")
-		exec(`DELETE FROM message_queue
-					WHERE id IN (
-							SELECT id
-							FROM message_queue
-							WHERE uuid = (
-								SELECT uuid
-								FROM last_activity
-								WHERE uuid = $1
-								LIMIT 1
-							)
-						  AND EXTRACT(EPOCH FROM (current_timestamp - timestamp_))*1000 >= $2
-					)`, uuid, GCTimeThreshold)
-	}
-
-	exec("INSERT INTO message_queue (uuid, message) VALUES ($1, $2)",
-		uuid, fmt.Sprintf("USER: %s", question))
-
-	exec(`INSERT INTO last_activity (uuid) VALUES ($1)
-													 ON CONFLICT (uuid)
-													 DO UPDATE SET timestamp_ = DEFAULT`, uuid)
+		gcMessages(ctx, conn, uuid, GCTimeThreshold)
+	}
 
-	if rateLimitTestMode == rateLimitByUUID || rateLimitTestMode == rateLimitByUUIDAndIpAddrHash {
-		exec(`INSERT INTO ratelimit (key) VALUES ($1)
-														ON CONFLICT (key)
-														DO UPDATE SET count = ratelimit.count + 1, timestamp_ = DEFAULT`, uuid)
+	// From here on, only lead capture sees the raw question (see redact.go)
+	raw := question
+	question, _ = redact(loadRedactors(), question)
+
+	// Spam only counts against the uuid, since an ipAddrHash may be shared by many visitors
+	guards := loadGuardConfig()
+	if last, ok := lastQuestion(ctx, conn, uuid, spamInterval); ok && isRepeatedQuestion(last, question) &&
+		!guards.Topic.isSmallTalk(question) && reportAbuse(ctx, conn, "spam", uuid) {
+		return answer{Text: localize(language, bannedMessage)}
 	}
 
-	if rateLimitTestMode == rateLimitByIpAddrHash || rateLimitTestMode == rateLimitByUUIDAndIpAddrHash {
-		exec(`INSERT INTO ratelimit (key) VALUES ($1)
-													 ON CONFLICT (key)
-													 DO UPDATE SET count = ratelimit.count + 1, timestamp_ = DEFAULT`, ipAddrHash)
+	// With the fake responses, the classifiers don't get a client either
+	guardClient := client
+	if settings.falseResponse {
+		guardClient = nil
+	}
+	if rule, flagged := guards.Injection.flags(ctx, guardClient, question); flagged {
+		log.Warnf("Injection guard flagged a question from %s (%s)", uuid, rule)
+		recordGuardIncident(ctx, conn, uuid, "injection", rule, question)
+		if reportAbuse(ctx, conn, "injection", uuid, ipAddrHash) {
+			return answer{Text: localize(language, bannedMessage)}
+		}
+		return answer{Text: localize(language, guards.Injection.Refusal)}
 	}
 
-	rows = query("SELECT message FROM message_queue WHERE uuid = $1 ORDER BY timestamp_ ASC", uuid)
-	defer finishRows(rows)
+	if reason, deflect := guards.Topic.offTopic(ctx, guardClient, question); deflect {
+		debugln(debugMode >= debugModeSimple, "Topic guard deflected a question: "+reason)
+		recordGuardIncident(ctx, conn, uuid, "topic", reason, question)
+		var history []string
+		for _, m := range sessionMessages(ctx, conn, uuid) {
+			history = append(history, m.text)
+		}
+		// Suggestions show what the visitor can ask instead
+		followUps, _ := loadFollowUpConfig().suggestFollowUps(ctx, nil, append(history, "USER: "+question), question,
+			"", language)
+		return answer{Text: localize(language, guards.Topic.Deflection), FollowUps: followUps}
+	}
+
+	insertMessage(ctx, conn, message{uuid: uuid, text: fmt.Sprintf("USER: %s", question), arm: arm.Name})
+
+	leads := storeLeads(ctx, conn, uuid, raw)
+
+	questionCount := touchSession(ctx, conn, uuid, ipAddrHash)
+
+	// Delivered in the background by the server, or by ./portfolio-chatbot notify (see notify.go)
+	if notifyConfig := loadNotifyConfig(); notifyConfig != nil {
+		notifyConfig.enqueueNotifications(ctx, conn, uuid, raw, question, leads, questionCount)
+	}
 
 	var recentQuestions []string
 	var questionSizes []int
 	var questionsSize int
 
-	for rows.Next() {
-		var question string
-		fail(rows.Scan(&question))
-		recentQuestions = append(recentQuestions, question)
-		questionSizes = append(questionSizes, len(question))
-		questionsSize += len(question)
+	for _, m := range sessionMessages(ctx, conn, uuid) {
+		recentQuestions = append(recentQuestions, m.text)
+		questionSizes = append(questionSizes, len(m.text))
+		questionsSize += len(m.text)
 	}
 
 	// Start deleting questions after this user has consumed more than ~10KB storage
@@ -381,14 +421,7 @@ func answerQuestion(uuid string, ipAddrHash string, question string, settings se
 				storageLimitPerClient/1024,
 				questionsSize)
 		}
-		exec(`DELETE FROM message_queue
-					WHERE id = (
-						SELECT id
-						FROM message_queue
-						WHERE uuid = $1
-						ORDER BY timestamp_ ASC
-						LIMIT 1
-				)`, uuid)
+		deleteOldestMessage(ctx, conn, uuid)
 		questionsSize -= questionSizes[0]
 		questionSizes = questionSizes[1:]
 	}
@@ -400,36 +433,104 @@ func answerQuestion(uuid string, ipAddrHash string, question string, settings se
 	debugln(debugMode >= debugModeSimple,
 		"--- BEGIN PREVIOUS CONVERSATION LOG ---\n"+strings.Join(recentQuestions, "\n")+"\n--- END PREVIOUS CONVERSATION LOG ---")
 
-	content := information() + "\n" + strings.Join(recentQuestions, "\n")
+	// Every answer comes with follow-up questions (see followups.go), whose tokens count towards the answer's.
+	// With the fake responses, they're only picked from the candidates.
+	followUpClient := client
+	if settings.falseResponse {
+		followUpClient = nil
+	}
+	reply := func(m message, response string) answer {
+		followUps, usage := loadFollowUpConfig().suggestFollowUps(ctx, followUpClient, recentQuestions, question,
+			response, language)
+		m.text = fmt.Sprintf("AI: %s", response)
+		m.promptTokens += usage.PromptTokens
+		m.completionTokens += usage.CompletionTokens
+		insertMessage(ctx, conn, m)
+		recordAnswerUsage(ctx, conn, m, len(response))
+		return answer{Text: response, FollowUps: followUps}
+	}
+
+	// Answers from the model cite the knowledge that supports them (see citations.go)
+	citedReply := func(m message, response string) answer {
+		text, citations, unsupported := citeAnswer(ctx, conn, uuid, response, language)
+		a := reply(m, text)
+		a.Citations, a.UnsupportedClaims = citations, unsupported
+		return a
+	}
+
+	// Common questions get an approved answer, without the model (see faq.go). The answers are in English.
+	if faq := loadFAQ(); faq != nil && language == defaultLanguage {
+		if intent, similarity := faq.grounded(knowledge()).match(question); intent != nil {
+			debugln(debugMode >= debugModeSimple, fmt.Sprintf("Answering from the FAQ (%s, %.2f)", intent.ID, similarity))
+			return reply(message{uuid: uuid, arm: arm.Name, model: "faq/" + intent.ID}, intent.answer(question))
+		}
+	}
+
+	// The last message is the question we just inserted
+	content := guards.Leak.plantCanaries(compilePrompt(arm.PromptVersion, recentQuestions[:len(recentQuestions)-1],
+		question, language))
 
 	if settings.falseResponse || client == nil {
 		falseResponseN[uuid]++
-		response := fmt.Sprintf("Response message #%d", falseResponseN[uuid])
-		exec(`INSERT INTO message_queue (uuid, message) VALUES ($1, $2)`, uuid, fmt.Sprintf("AI: %s", response))
-		return response
+		return reply(message{uuid: uuid, arm: arm.Name}, fmt.Sprintf("Response message #%d", falseResponseN[uuid]))
 	} else {
+		// Answers to context-free questions are the same for everyone, so they can be cached (see cache.go)
+		cache, cacheKey := loadCacheConfig(), ""
+		if cache != nil && len(recentQuestions) == 1 {
+			knowledge := compilePrompt(arm.PromptVersion, nil, "", language)
+			if fileExists(careerFile) {
+				knowledge += readFile(careerFile)
+			}
+			cacheKey = answerCacheKey(arm, knowledge, question)
+			if response, hit := cachedAnswer(ctx, conn, cacheKey, cache.TTL); hit {
+				debugln(debugMode >= debugModeSimple, "Answering from the cache")
+				return citedReply(message{uuid: uuid, arm: arm.Name, model: arm.Model}, response)
+			}
+		}
+
 		// https://pkg.go.dev/github.com/sashabaranov/go-openai#Client.CreateChatCompletion
-		resp, err := client.CreateChatCompletion(ctx,
-			openai.ChatCompletionRequest{
-				Model:     openai.GPT4,
-				MaxTokens: 200,
-				Messages: []openai.ChatCompletionMessage{
-					{
-						Role:    openai.ChatMessageRoleUser,
-						Content: content,
-					},
+		request := openai.ChatCompletionRequest{
+			Model:     arm.Model,
+			MaxTokens: arm.MaxTokens,
+			Messages: []openai.ChatCompletionMessage{
+				{
+					Role:    openai.ChatMessageRoleUser,
+					Content: content,
 				},
-			})
+			},
+		}
+		var resp openai.ChatCompletionResponse
+		var err error
+		// With structured career data, the model can look things up instead of relying on the prompt (see tools.go)
+		if career := loadCareer(); career != nil {
+			resp, err = career.askWithTools(ctx, client, request)
+		} else {
+			resp, err = client.CreateChatCompletion(ctx, request)
+		}
 
 		fail(err)
 		response := resp.Choices[0].Message.Content
-		exec(`INSERT INTO message_queue (uuid, message) VALUES ($1, $2)`, uuid, fmt.Sprintf("AI: %s", response))
-
-		return response
+		m := message{uuid: uuid, arm: arm.Name, model: arm.Model, promptTokens: resp.Usage.PromptTokens,
+			completionTokens: resp.Usage.CompletionTokens}
+		if rule, leaked := guards.Leak.leaks(response, promptInstructions(arm.PromptVersion)); leaked {
+			log.Warnf("Leak guard caught an answer to %s (%s)", uuid, rule)
+			recordGuardIncident(ctx, conn, uuid, "leak", rule, response)
+			return reply(m, localize(language, guards.Leak.SafeReply))
+		}
+		if cacheKey != "" {
+			cacheAnswer(ctx, conn, cacheKey, response)
+		}
+		return citedReply(m, response)
 	}
 }
 
 func setupDB(ctx context.Context) *pgx.Conn {
+	return setupDBSchema(ctx, "")
+}
+
+// setupDBSchema is setupDB in a schema of its own (which is created if needed), so that e.g. tests can't touch the
+// real tables. The empty schema is the default one.
+func setupDBSchema(ctx context.Context, schema string) *pgx.Conn {
 
 	conn, err := pgx.Connect(ctx, "postgres://portfolio_cb_user@localhost:5432/portfolio_cb")
 	if err != nil {
@@ -441,6 +542,11 @@ func setupDB(ctx context.Context) *pgx.Conn {
 		unwrap(conn.Exec(ctx, query, args...))
 	}
 
+	if schema != "" {
+		exec("CREATE SCHEMA IF NOT EXISTS " + pgx.Identifier{schema}.Sanitize())
+		exec("SET search_path TO " + pgx.Identifier{schema}.Sanitize())
+	}
+
 	exec(`CREATE TABLE IF NOT EXISTS message_queue (id SERIAL PRIMARY KEY,
 																									uuid TEXT,
 																									message TEXT,
@@ -453,9 +559,153 @@ func setupDB(ctx context.Context) *pgx.Conn {
 																							count INTEGER DEFAULT 1,
 																							timestamp_ TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`)
 
+	// Experiment arms (see experiment.go)
+	exec(`ALTER TABLE message_queue ADD COLUMN IF NOT EXISTS arm TEXT,
+																	ADD COLUMN IF NOT EXISTS model TEXT,
+																	ADD COLUMN IF NOT EXISTS prompt_tokens INTEGER,
+																	ADD COLUMN IF NOT EXISTS completion_tokens INTEGER`)
+
+	exec(`CREATE TABLE IF NOT EXISTS ratelimit_hits (uuid TEXT,
+																									 arm TEXT,
+																									 timestamp_ TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`)
+
+	exec(`CREATE TABLE IF NOT EXISTS feedback (uuid TEXT,
+																						 arm TEXT,
+																						 rating INTEGER,
+																						 timestamp_ TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`)
+
+	// message_queue is pruned, so what each answer cost is kept here too
+	exec(`CREATE TABLE IF NOT EXISTS answer_usage (uuid TEXT,
+																								 arm TEXT,
+																								 model TEXT,
+																								 length INTEGER,
+																								 prompt_tokens INTEGER,
+																								 completion_tokens INTEGER,
+																								 timestamp_ TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`)
+
+	// Notifications (see notify.go)
+	exec(`ALTER TABLE last_activity ADD COLUMN IF NOT EXISTS questions INTEGER DEFAULT 1`)
+
+	exec(`CREATE TABLE IF NOT EXISTS notifications (key TEXT PRIMARY KEY,
+																									sink TEXT,
+																									payload TEXT,
+																									attempts INTEGER DEFAULT 0,
+																									delivered BOOLEAN DEFAULT false,
+																									next_attempt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+																									timestamp_ TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`)
+
+	// Data requests find notifications by uuid, which can't be parsed out of the key (sink ids may contain anything)
+	exec(`ALTER TABLE notifications ADD COLUMN IF NOT EXISTS uuid TEXT`)
+	exec(`UPDATE notifications SET uuid = payload::json->>'uuid' WHERE uuid IS NULL AND payload LIKE '{%'`)
+
+	// Leads (see leads.go)
+	exec(`CREATE TABLE IF NOT EXISTS leads (id SERIAL PRIMARY KEY,
+																					uuid TEXT,
+																					kind TEXT,
+																					value TEXT,
+																					value_hash TEXT,
+																					consent TEXT DEFAULT 'unknown',
+																					timestamp_ TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`)
+
+	// Lead values can be encrypted (see redact.go and crypto.go), so they're de-duplicated by a blind index instead
+	exec(`ALTER TABLE leads ADD COLUMN IF NOT EXISTS value_hash TEXT`)
+	exec(`ALTER TABLE leads DROP CONSTRAINT IF EXISTS leads_uuid_kind_value_key`)
+	exec(`CREATE UNIQUE INDEX IF NOT EXISTS leads_uuid_kind_value_hash ON leads (uuid, kind, value_hash)`)
+
+	exec(`CREATE TABLE IF NOT EXISTS admin_log (id SERIAL PRIMARY KEY,
+																							action TEXT,
+																							reason TEXT,
+																							timestamp_ TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`)
+
+	// Data subject requests (see dsr.go)
+	exec(`ALTER TABLE last_activity ADD COLUMN IF NOT EXISTS ip_addr_hash TEXT`)
+
+	exec(`CREATE TABLE IF NOT EXISTS erasures (id SERIAL PRIMARY KEY,
+																						 subject_kind TEXT,
+																						 subject_hash TEXT,
+																						 requested_by TEXT,
+																						 deleted TEXT,
+																						 timestamp_ TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`)
+
+	// Session token signing keys (see session.go)
+	exec(`CREATE TABLE IF NOT EXISTS session_keys (id SERIAL PRIMARY KEY,
+																								 secret TEXT,
+																								 timestamp_ TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`)
+
+	// Bans and abuse scores (see abuse.go)
+	exec(`CREATE TABLE IF NOT EXISTS bans (id SERIAL PRIMARY KEY,
+																				 key TEXT,
+																				 reason TEXT,
+																				 created_by TEXT,
+																				 expires TIMESTAMP,
+																				 timestamp_ TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`)
+
+	exec(`CREATE TABLE IF NOT EXISTS abuse_events (key TEXT,
+																								 event TEXT,
+																								 points INTEGER,
+																								 timestamp_ TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`)
+
+	// Guards (see guard.go)
+	exec(`CREATE TABLE IF NOT EXISTS guard_incidents (id SERIAL PRIMARY KEY,
+																										uuid TEXT,
+																										guard TEXT,
+																										rule TEXT,
+																										text TEXT,
+																										timestamp_ TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`)
+
+	// Answer cache (see cache.go)
+	exec(`CREATE TABLE IF NOT EXISTS answer_cache (key TEXT PRIMARY KEY,
+																								 answer TEXT,
+																								 timestamp_ TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`)
+
+	exec(`CREATE TABLE IF NOT EXISTS answer_cache_lookups (hit BOOLEAN,
+																												 timestamp_ TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`)
+
+	// Salts for hashing IP addresses (see ipaddr.go)
+	exec(`CREATE TABLE IF NOT EXISTS ip_salts (id SERIAL PRIMARY KEY,
+																						 day DATE UNIQUE,
+																						 secret TEXT)`)
+
+	// Bans on IP addresses are keyed by a hash with a salt that doesn't change, and today's hashes link to it
+	exec(`CREATE TABLE IF NOT EXISTS ban_salt (id INTEGER PRIMARY KEY,
+																						 secret TEXT)`)
+
+	exec(`CREATE TABLE IF NOT EXISTS ip_links (hash TEXT PRIMARY KEY,
+																						 ban_key TEXT,
+																						 day DATE)`)
+
+	// Languages (see language.go)
+	exec(`CREATE TABLE IF NOT EXISTS session_languages (uuid TEXT PRIMARY KEY,
+																										 language TEXT,
+																										 timestamp_ TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`)
+
+	// Fit analyses (see fit.go)
+	exec(`CREATE TABLE IF NOT EXISTS fit_analyses (id SERIAL PRIMARY KEY,
+																								 uuid TEXT,
+																								 model TEXT,
+																								 prompt_tokens INTEGER,
+																								 completion_tokens INTEGER,
+																								 timestamp_ TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`)
+
 	return conn
 }
 
+// Subcommands take precedence over command mode, so their names must never look like a uuid
+var subcommands = map[string]func(args []string, settings settings, ctx context.Context, conn *pgx.Conn){
+	"admin":      adminCommand,
+	"data":       dataCommand,
+	"eval":       evalCommand,
+	"export":     exportCommand,
+	"feedback":   feedbackCommand,
+	"fit":        fitCommand,
+	"leads":      leadsCommand,
+	"notify":     notifyCommand,
+	"report":     reportCommand,
+	"rotate-key": rotateKeyCommand,
+	"serve":      serveCommand,
+	"session":    sessionCommand,
+}
+
 func main() {
 
 	settings := getSettings() // handle failure early
@@ -464,11 +714,25 @@ func main() {
 	defer conn.Close(ctx)
 
 	if len(os.Args) > 1 {
-		// command mode
-		if len(os.Args) == 4 {
-			fmt.Println(answerQuestion(os.Args[1], os.Args[2], os.Args[3], settings, ctx, conn, initializeClient(), __debugModeOff))
+		if subcommand, ok := subcommands[os.Args[1]]; ok {
+			subcommand(os.Args[2:], settings, ctx, conn)
+		} else if len(os.Args) == 4 || len(os.Args) == 5 {
+			// command mode; session tokens come from ./portfolio-chatbot session (see session.go)
+			uuid_, err := verifySessionToken(ctx, conn, os.Args[1])
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			language := ""
+			if len(os.Args) == 5 {
+				language = visitorLanguage(ctx, conn, uuid_, normalizeInput(os.Args[3], settings.mapConfusables),
+					os.Args[4])
+			}
+			fmt.Println(answerQuestion(uuid_, os.Args[2], os.Args[3], language, settings, ctx, conn, initializeClient(),
+				__debugModeOff).Text)
 		} else {
-			fmt.Println("Error: Wrong format: Should be ./portfolio-chatbot {uuid} {ipAddrHash} \"{question}\".")
+			fmt.Println("Error: Wrong format: Should be ./portfolio-chatbot {token} {ipAddrHash} \"{question}\" " +
+				"[language], or ./portfolio-chatbot {subcommand} ...")
 		}
 	} else {
 		// interactive mode
@@ -480,7 +744,11 @@ func main() {
 		fmt.Println("(interactive mode) Hello! I am portfolio-chatbot. Please go ahead and ask me any questions you have about Kris!")
 		for scanner.Scan() {
 			settings = getSettings() // settings may have changed by now
-			fmt.Println(answerQuestion(uuid_, fakeAddressHash, scanner.Text(), settings, ctx, conn, client, debugMode))
+			reply := answerQuestion(uuid_, fakeAddressHash, scanner.Text(), "", settings, ctx, conn, client, debugMode)
+			fmt.Println(reply.Text)
+			for _, followUp := range reply.FollowUps {
+				fmt.Println("  > " + followUp)
+			}
 		}
 	}
 }
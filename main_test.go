@@ -18,6 +18,24 @@ func testAssert(t *testing.T, cond bool) {
 	}
 }
 
+// setupTestDB connects to a throwaway schema, which is dropped when the test ends, for tests that mustn't see (or
+// leave behind) the real rows
+func setupTestDB(t *testing.T, ctx context.Context) *pgx.Conn {
+	schema := "test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	conn := setupDBSchema(ctx, schema)
+	t.Cleanup(func() {
+		unwrap(conn.Exec(ctx, "DROP SCHEMA "+schema+" CASCADE"))
+		conn.Close(ctx)
+	})
+	return conn
+}
+
+// stubResume stands in for resume.pdf until the test ends
+func stubResume(t *testing.T, text string) {
+	resume = func() string { return text }
+	t.Cleanup(func() { resume = readResume })
+}
+
 func testRateLimit(t *testing.T) {
 
 	settings := getSettings()
@@ -36,23 +54,26 @@ func testRateLimit(t *testing.T) {
 	question := "Where is Kris?"
 	debugMode := __debugModeOff
 
+	// A different question every time, since asking the same one over and over is spam (see abuse.go)
 	for i := 0; i < settings.rateLimitCount; i++ {
-		testAssert(t, answerQuestion(uuid_, ipAddrHash, question, settings, ctx, conn, nil, debugMode) ==
+		question := fmt.Sprintf("%s (%d)", question, i)
+		testAssert(t, answerQuestion(uuid_, ipAddrHash, question, "", settings, ctx, conn, nil, debugMode).Text ==
 			fmt.Sprintf("Response message #%d", i+1))
 	}
 
-	testAssert(t, answerQuestion(uuid_, ipAddrHash, question, settings, ctx, conn, nil, debugMode) ==
-		rateLimitMessage(settings.rateLimitDelay/1000))
+	testAssert(t, answerQuestion(uuid_, ipAddrHash, question, "", settings, ctx, conn, nil, debugMode).Text ==
+		rateLimitMessage(defaultLanguage, settings.rateLimitDelay/1000))
 
 	time.Sleep(time.Millisecond * time.Duration(settings.rateLimitDelay))
 
 	for i := settings.rateLimitCount; i < settings.rateLimitCount*2; i++ {
-		testAssert(t, answerQuestion(uuid_, ipAddrHash, question, settings, ctx, conn, nil, debugMode) ==
+		question := fmt.Sprintf("%s (%d)", question, i)
+		testAssert(t, answerQuestion(uuid_, ipAddrHash, question, "", settings, ctx, conn, nil, debugMode).Text ==
 			fmt.Sprintf("Response message #%d", i+1))
 	}
 
-	testAssert(t, answerQuestion(uuid_, ipAddrHash, question, settings, ctx, conn, nil, debugMode) ==
-		rateLimitMessage(settings.rateLimitDelay/1000))
+	testAssert(t, answerQuestion(uuid_, ipAddrHash, question, "", settings, ctx, conn, nil, debugMode).Text ==
+		rateLimitMessage(defaultLanguage, settings.rateLimitDelay/1000))
 
 }
 
@@ -123,23 +144,23 @@ func TestHistoryPruning(t *testing.T) {
 
 	_ = printList
 
-	answerQuestion(uuid_, ipAddrHash, questions[0], getSettings(), ctx, conn, nil, debugMode)
+	answerQuestion(uuid_, ipAddrHash, questions[0], "", getSettings(), ctx, conn, nil, debugMode)
 	testAssert(t, len(getDataSoFar()) == 2 && getDataSoFar()[0] == "USER: "+questions[0])
 	// printList(getDataSoFar())
 
-	answerQuestion(uuid_, ipAddrHash, questions[1], getSettings(), ctx, conn, nil, debugMode)
+	answerQuestion(uuid_, ipAddrHash, questions[1], "", getSettings(), ctx, conn, nil, debugMode)
 	testAssert(t, len(getDataSoFar()) == 4 && getDataSoFar()[2] == "USER: "+questions[1])
 	// printList(getDataSoFar())
 
-	answerQuestion(uuid_, ipAddrHash, questions[2], getSettings(), ctx, conn, nil, debugMode)
+	answerQuestion(uuid_, ipAddrHash, questions[2], "", getSettings(), ctx, conn, nil, debugMode)
 	testAssert(t, len(getDataSoFar()) == 4 && getDataSoFar()[2] == "USER: "+questions[2])
 	// printList(getDataSoFar())
 
-	answerQuestion(uuid_, ipAddrHash, questions[3], getSettings(), ctx, conn, nil, debugMode)
+	answerQuestion(uuid_, ipAddrHash, questions[3], "", getSettings(), ctx, conn, nil, debugMode)
 	testAssert(t, len(getDataSoFar()) == 4 && getDataSoFar()[2] == "USER: "+questions[3])
 	// printList(getDataSoFar())
 
-	answerQuestion(uuid_, ipAddrHash, questions[4], getSettings(), ctx, conn, nil, debugMode)
+	answerQuestion(uuid_, ipAddrHash, questions[4], "", getSettings(), ctx, conn, nil, debugMode)
 	testAssert(t, len(getDataSoFar()) == 4 && getDataSoFar()[2] == "USER: "+questions[4])
 	// printList(getDataSoFar())
 }
@@ -185,7 +206,8 @@ func TestMessageGC(t *testing.T) {
 	for i := 0; i < iterationsI; i++ {
 		for j := 0; j < iterationsJ; j++ {
 			count := getMessageCount()
-			answerQuestion(uuid_, ipAddrHash, question, settings, ctx, conn, nil, debugMode)
+			answerQuestion(uuid_, ipAddrHash, fmt.Sprintf("%s (%d, %d)", question, i, j), "", settings, ctx, conn,
+				nil, debugMode)
 			// This will only count GCs that removed data, not all GCs
 			if getMessageCount() < count {
 				GCs++
@@ -211,6 +233,6 @@ func BenchmarkFalseResponse(b *testing.B) {
 		ipAddrHash := uuid.NewString()
 		question := "Where is Kris?"
 
-		answerQuestion(uuid_, ipAddrHash, question, getSettings(), ctx, conn, nil, __debugModeOff)
+		answerQuestion(uuid_, ipAddrHash, question, "", getSettings(), ctx, conn, nil, __debugModeOff)
 	}
 }
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// confusables maps Cyrillic and Greek letters to the Latin letters they look like
+var confusables = map[rune]rune{
+	'а': 'a', 'в': 'b', 'е': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p', 'с': 'c', 'т': 't', 'у': 'y',
+	'х': 'x', 'і': 'i', 'ј': 'j', 'ѕ': 's', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w',
+	'А': 'A', 'В': 'B', 'Е': 'E', 'К': 'K', 'М': 'M', 'Н': 'H', 'О': 'O', 'Р': 'P', 'С': 'C', 'Т': 'T', 'Х': 'X',
+	'І': 'I', 'Ј': 'J', 'Ѕ': 'S',
+	'α': 'a', 'ο': 'o', 'ρ': 'p', 'ν': 'v', 'ι': 'i', 'κ': 'k', 'τ': 't', 'υ': 'u',
+	'Α': 'A', 'Β': 'B', 'Ε': 'E', 'Ζ': 'Z', 'Η': 'H', 'Ι': 'I', 'Κ': 'K', 'Μ': 'M', 'Ν': 'N', 'Ο': 'O', 'Ρ': 'P',
+	'Τ': 'T', 'Υ': 'Y', 'Χ': 'X',
+}
+
+// mapConfusables replaces confusable letters in words that mix them with Latin letters (e.g., "Krіs" with a
+// Cyrillic і), which is how homoglyphs are used to sneak words past the guards. Words that are entirely Cyrillic or
+// Greek are left alone, so questions in Russian or Greek still read the same.
+func mapConfusables(text string) string {
+	words := strings.FieldsFunc(text, func(r rune) bool { return !unicode.IsLetter(r) })
+	replacements := make([]string, 0, 2*len(words))
+	for _, word := range words {
+		latin, confusable := false, false
+		for _, r := range word {
+			if unicode.Is(unicode.Latin, r) {
+				latin = true
+			} else if _, ok := confusables[r]; ok {
+				confusable = true
+			}
+		}
+		if !latin || !confusable {
+			continue
+		}
+		replacements = append(replacements, word, strings.Map(func(r rune) rune {
+			if latin, ok := confusables[r]; ok {
+				return latin
+			}
+			return r
+		}, word))
+	}
+	return strings.NewReplacer(replacements...).Replace(text)
+}
+
+// joins tells whether a zero-width joiner or non-joiner next to a rune is part of the text: Persian, Hindi and other
+// scripts need them between letters, and so do emoji sequences. Next to Latin letters, they only hide words from the
+// guards.
+func joins(r rune) bool {
+	return (unicode.IsLetter(r) || unicode.IsMark(r) || unicode.In(r, unicode.So, unicode.Sk)) &&
+		!unicode.Is(unicode.Latin, r)
+}
+
+// normalizeInput is applied to every question before anything else looks at it. It NFC-normalizes the question (so
+// that "é" is one rune however it was typed), strips control and invisible characters (e.g., zero-width spaces and
+// bidirectional overrides, but not zero-width (non-)joiners between non-Latin letters or emoji, see joins), collapses
+// spaces and blank lines, and optionally maps confusables (see mapConfusables). Line breaks are kept, since the
+// injection guard looks for fake "SYSTEM:" lines.
+func normalizeInput(text string, confusables bool) string {
+	runes := []rune(norm.NFC.String(text))
+	var sb strings.Builder
+	for i, r := range runes {
+		switch {
+		case r == '\n':
+			sb.WriteRune(r)
+		case r == '\r':
+			// dropped
+		case unicode.IsSpace(r):
+			sb.WriteRune(' ')
+		case (r == '\u200c' || r == '\u200d') && i > 0 && i+1 < len(runes) && joins(runes[i-1]) && joins(runes[i+1]):
+			sb.WriteRune(r)
+		case unicode.IsControl(r), unicode.Is(unicode.Cf, r), r == unicode.ReplacementChar:
+			// dropped
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	text = sb.String()
+	if confusables {
+		text = mapConfusables(text)
+	}
+
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// Notifications are off unless this file exists (see notifications.example.yaml)
+const notifyFile = "notifications.yaml"
+
+const notifyTimeout = 5 * time.Second
+
+// In server mode, pending notifications are delivered this often (see deliverNotificationsEvery)
+const notifyInterval = 30 * time.Second
+
+type sinkConfig struct {
+	Type string `yaml:"type"` // webhook, smtp or file
+	// webhook
+	URL string `yaml:"url"`
+	// smtp
+	Host         string   `yaml:"host"`
+	Port         int      `yaml:"port"`
+	From         string   `yaml:"from"`
+	To           []string `yaml:"to"`
+	Username     string   `yaml:"username"`
+	PasswordFile string   `yaml:"password-file"`
+	// file
+	Path string `yaml:"path"`
+}
+
+type notifyConfig struct {
+	NewSession    bool         `yaml:"new-session"`
+	QuestionCount int          `yaml:"question-count"`
+	Keywords      []string     `yaml:"keywords"`
+	ContactInfo   bool         `yaml:"contact-info"`
+	MaxAttempts   int          `yaml:"max-attempts"`
+	Sinks         []sinkConfig `yaml:"sinks"`
+}
+
+type notification struct {
+	Trigger  string `json:"trigger"`
+	UUID     string `json:"uuid"`
+	Question string `json:"question"`
+	Text     string `json:"text"`
+}
+
+// loadNotifyConfig returns nil if notifications are off
+func loadNotifyConfig() *notifyConfig {
+	if !fileExists(notifyFile) {
+		return nil
+	}
+	config := notifyConfig{MaxAttempts: 5}
+	decoder := yaml.NewDecoder(strings.NewReader(readFile(notifyFile)))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil {
+		log.Fatalf("%s: %v", notifyFile, err)
+	}
+	for _, sink := range config.Sinks {
+		switch sink.Type {
+		case "webhook":
+			if sink.URL == "" {
+				log.Fatalf("%s: webhook sink is missing a url", notifyFile)
+			}
+		case "smtp":
+			if sink.Host == "" || sink.Port == 0 || sink.From == "" || len(sink.To) == 0 {
+				log.Fatalf("%s: smtp sink needs a host, port, from and to", notifyFile)
+			}
+		case "file":
+			if sink.Path == "" {
+				log.Fatalf("%s: file sink is missing a path", notifyFile)
+			}
+		default:
+			log.Fatalf("%s: Invalid sink type '%s'", notifyFile, sink.Type)
+		}
+	}
+	return &config
+}
+
+// id identifies a sink in the notifications table, so that a notification that reached one sink is only retried
+// for the others
+func (sink sinkConfig) id() string {
+	switch sink.Type {
+	case "webhook":
+		return "webhook:" + sink.URL
+	case "smtp":
+		return fmt.Sprintf("smtp:%s:%d:%s", sink.Host, sink.Port, strings.Join(sink.To, ","))
+	default:
+		return "file:" + sink.Path
+	}
+}
+
+func (sink sinkConfig) deliver(n notification) error {
+	switch sink.Type {
+	case "webhook":
+		client := http.Client{Timeout: notifyTimeout}
+		resp, err := client.Post(sink.URL, "application/json", bytes.NewReader(unwrap(json.Marshal(n))))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("webhook %s: %s", sink.URL, resp.Status)
+		}
+		return nil
+	case "smtp":
+		return sink.sendMail(n)
+	default:
+		f, err := os.OpenFile(sink.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = f.Write(append(unwrap(json.Marshal(n)), '\n'))
+		return err
+	}
+}
+
+// sendMail is smtp.SendMail with a timeout
+func (sink sinkConfig) sendMail(n notification) error {
+	addr := net.JoinHostPort(sink.Host, strconv.Itoa(sink.Port))
+	conn, err := net.DialTimeout("tcp", addr, notifyTimeout)
+	if err != nil {
+		return err
+	}
+	fail(conn.SetDeadline(time.Now().Add(notifyTimeout)))
+	c, err := smtp.NewClient(conn, sink.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: sink.Host}); err != nil {
+			return err
+		}
+	}
+	if sink.Username != "" {
+		password := strings.TrimRight(readFile(sink.PasswordFile), "\r\n")
+		if err := c.Auth(smtp.PlainAuth("", sink.Username, password, sink.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(sink.From); err != nil {
+		return err
+	}
+	for _, to := range sink.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "From: %s\r\nTo: %s\r\nSubject: portfolio-chatbot: %s\r\n\r\n%s\r\n", sink.From,
+		strings.Join(sink.To, ", "), n.Trigger, strings.ReplaceAll(n.Text, "\n", "\r\n"))
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// notificationTriggers decides which notifications a question fires. Each one comes with a key that is unique per
+// visitor, so that e.g. the "salary" keyword only notifies once per visitor however often they repeat it. Triggers
+// look at the raw question, but notifications only ever contain the redacted one (see redact.go). Contact details
+// only fire once they're stored as leads, since that's where the notification points the owner to.
+func (config notifyConfig) notificationTriggers(uuid, raw, question string, leads []contactDetail,
+	questionCount int) map[string]notification {
+
+	notifications := make(map[string]notification)
+	add := func(key, trigger, text string) {
+		notifications[trigger+"/"+uuid+"/"+key] = notification{trigger, uuid, question,
+			fmt.Sprintf("%s\n\nVisitor: %s\nQuestion: %s", text, uuid, question)}
+	}
+
+	if config.NewSession && questionCount == 1 {
+		add("", "new-session", "A new visitor started chatting.")
+	}
+	if config.QuestionCount > 0 && questionCount == config.QuestionCount {
+		add("", "question-count", fmt.Sprintf("A visitor has asked %d questions.", questionCount))
+	}
+	lower := strings.ToLower(raw)
+	for _, keyword := range config.Keywords {
+		if strings.Contains(lower, strings.ToLower(keyword)) {
+			add(strings.ToLower(keyword), "keyword", fmt.Sprintf("A visitor asked about \"%s\".", keyword))
+		}
+	}
+	if config.ContactInfo {
+		key := masterKey()
+		for _, detail := range leads {
+			if detail.kind == "company" {
+				continue
+			}
+			add(blindIndex(key, detail.value), "contact-info",
+				fmt.Sprintf("A visitor left their %s (see ./portfolio-chatbot leads export).", detail.kind))
+		}
+	}
+	return notifications
+}
+
+// enqueueNotifications adds the notifications a question fires to the notifications table, once per sink. Keys that
+// are already there (delivered or not) are ignored, which de-duplicates notifications across processes. Payloads
+// contain the question, so they're sealed like the conversation (see crypto.go).
+func (config notifyConfig) enqueueNotifications(ctx context.Context, conn *pgx.Conn, uuid, raw, question string,
+	leads []contactDetail, questionCount int) {
+
+	for key, n := range config.notificationTriggers(uuid, raw, question, leads, questionCount) {
+		for _, sink := range config.Sinks {
+			unwrap(conn.Exec(ctx, `INSERT INTO notifications (key, uuid, sink, payload) VALUES ($1, $2, $3, $4)
+															 ON CONFLICT (key) DO NOTHING`,
+				sink.id()+"|"+key, uuid, sink.id(), sealText(masterKey(), string(unwrap(json.Marshal(n))))))
+		}
+	}
+}
+
+// deliverNotifications tries to deliver every pending notification once. Failed deliveries are retried with
+// exponential backoff by later calls, up to max-attempts times.
+func (config notifyConfig) deliverNotifications(ctx context.Context, conn *pgx.Conn) {
+	type pending struct {
+		key, sink, payload string
+	}
+
+	rows := unwrap(conn.Query(ctx, `SELECT key, sink, payload
+																	FROM notifications
+																	WHERE NOT delivered
+																	AND attempts < $1
+																	AND next_attempt <= current_timestamp
+																	ORDER BY timestamp_ ASC
+																	LIMIT 20`, config.MaxAttempts))
+	var queue []pending
+	for rows.Next() {
+		var p pending
+		fail(rows.Scan(&p.key, &p.sink, &p.payload))
+		queue = append(queue, p)
+	}
+	finishRows(rows)
+
+	sinks := make(map[string]sinkConfig)
+	for _, sink := range config.Sinks {
+		sinks[sink.id()] = sink
+	}
+
+	for _, p := range queue {
+		sink, ok := sinks[p.sink]
+		if !ok {
+			// The sink was removed from the config since; leave the notification for the record
+			continue
+		}
+		payload, err := openText(p.payload, masterKey())
+		if err != nil {
+			log.Errorf("Failed to unseal notification %s: %v", p.key, err)
+			continue
+		}
+		var n notification
+		fail(json.Unmarshal([]byte(payload), &n))
+		if err := sink.deliver(n); err != nil {
+			log.Errorf("Failed to deliver notification %s: %v", p.key, err)
+			unwrap(conn.Exec(ctx, `UPDATE notifications
+														 SET attempts = attempts + 1,
+														 next_attempt = current_timestamp + interval '1 minute' * power(2, attempts)
+														 WHERE key = $1`, p.key))
+		} else {
+			unwrap(conn.Exec(ctx, "UPDATE notifications SET attempts = attempts + 1, delivered = true WHERE key = $1",
+				p.key))
+		}
+	}
+}
+
+// deliverNotificationsEvery delivers pending notifications in the background until ctx is done, so that a slow sink
+// never holds up a visitor. It needs a connection of its own, since a pgx.Conn can't be used concurrently.
+func deliverNotificationsEvery(ctx context.Context, conn *pgx.Conn, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if config := loadNotifyConfig(); config != nil {
+			config.deliverNotifications(ctx, conn)
+		}
+	}
+}
+
+// notifyCommand delivers pending notifications (e.g., from cron, which is how they're delivered outside server mode)
+func notifyCommand(args []string, settings settings, ctx context.Context, conn *pgx.Conn) {
+	if config := loadNotifyConfig(); config != nil {
+		config.deliverNotifications(ctx, conn)
+	} else {
+		fmt.Printf("Notifications are off (%s does not exist)\n", notifyFile)
+	}
+}
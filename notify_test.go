@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSMTPServer accepts a single message and sends its DATA to the returned channel
+func fakeSMTPServer(t *testing.T) (string, int, chan string) {
+	listener := unwrap(net.Listen("tcp", "127.0.0.1:0"))
+	t.Cleanup(func() { listener.Close() })
+	data := make(chan string, 1)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+		reply("220 localhost")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch verb := strings.ToUpper(strings.Fields(line)[0]); verb {
+			case "EHLO", "HELO", "MAIL", "RCPT":
+				reply("250 OK")
+			case "DATA":
+				reply("354 Go ahead")
+				var sb strings.Builder
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					sb.WriteString(line)
+				}
+				data <- sb.String()
+				reply("250 OK")
+			case "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("502 Not implemented")
+			}
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, data
+}
+
+func TestNotificationSinks(t *testing.T) {
+
+	n := notification{"keyword", "some-uuid", "What salary does Kris want?", "A visitor asked about \"salary\"."}
+
+	var received notification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fail(json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer server.Close()
+	testAssert(t, sinkConfig{Type: "webhook", URL: server.URL}.deliver(n) == nil)
+	testAssert(t, received == n)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	testAssert(t, sinkConfig{Type: "webhook", URL: failing.URL}.deliver(n) != nil)
+
+	host, port, data := fakeSMTPServer(t)
+	smtpSink := sinkConfig{Type: "smtp", Host: host, Port: port, From: "bot@example.com", To: []string{"kris@example.com"}}
+	testAssert(t, smtpSink.deliver(n) == nil)
+	mail := <-data
+	testAssert(t, strings.Contains(mail, "Subject: portfolio-chatbot: keyword") && strings.Contains(mail, n.Text))
+
+	path := filepath.Join(t.TempDir(), "notifications.log")
+	testAssert(t, sinkConfig{Type: "file", Path: path}.deliver(n) == nil)
+	testAssert(t, sinkConfig{Type: "file", Path: path}.deliver(n) == nil)
+	testAssert(t, strings.Count(readFile(path), "\n") == 2)
+}
+
+func TestNotificationTriggers(t *testing.T) {
+
+	config := notifyConfig{NewSession: true, QuestionCount: 3, Keywords: []string{"Salary", "interview"}, ContactInfo: true}
+
+	testAssert(t, len(config.notificationTriggers("u", "Where is Kris?", "Where is Kris?", nil, 1)) == 1)
+	testAssert(t, len(config.notificationTriggers("u", "Where is Kris?", "Where is Kris?", nil, 2)) == 0)
+	testAssert(t, len(config.notificationTriggers("u", "Where is Kris?", "Where is Kris?", nil, 3)) == 1)
+	salary := "Is a salary interview possible?"
+	testAssert(t, len(config.notificationTriggers("u", salary, salary, nil, 2)) == 2)
+
+	// Contact details come from the raw question, but never end up in a notification...
+	raw := "Email me at jane@example.com or call (555) 123-4567"
+	question, _ := redact(builtinRedactors, raw)
+	// ...and only once they're stored as leads
+	testAssert(t, len(config.notificationTriggers("u", raw, question, nil, 2)) == 0)
+	notifications := config.notificationTriggers("u", raw, question, detectLeads(raw), 2)
+	testAssert(t, len(notifications) == 2)
+	for key, n := range notifications {
+		testAssert(t, !strings.Contains(key+n.Question+n.Text, "jane@example.com"))
+		testAssert(t, !strings.Contains(key+n.Question+n.Text, "123-4567"))
+	}
+
+	// The same trigger has the same key, which is what de-duplicates it
+	for key := range config.notificationTriggers("u", "What's the salary?", "What's the salary?", nil, 2) {
+		_, ok := config.notificationTriggers("u", "And the SALARY again?", "And the SALARY again?", nil, 5)[key]
+		testAssert(t, ok)
+	}
+}
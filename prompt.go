@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+const promptDir = "prompts"
+
+// promptSlots is what a prompt template is executed with. Every prompt must use every required slot.
+type promptSlots struct {
+	Resume   string
+	Facts    string
+	History  string
+	Question string
+	Language string // the name of the language to answer in, e.g., "Spanish" (see language.go)
+}
+
+var requiredPromptSlots = []string{"Resume", "Facts", "History", "Question"}
+
+// Prompts from before the optional slots may leave them out
+var optionalPromptSlots = []string{"Language"}
+
+// promptFields collects the names of every .Field referenced anywhere under node
+func promptFields(node parse.Node, fields map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n != nil {
+			for _, child := range n.Nodes {
+				promptFields(child, fields)
+			}
+		}
+	case *parse.ActionNode:
+		promptFields(n.Pipe, fields)
+	case *parse.PipeNode:
+		if n != nil {
+			for _, cmd := range n.Cmds {
+				promptFields(cmd, fields)
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			promptFields(arg, fields)
+		}
+	case *parse.FieldNode:
+		fields[n.Ident[0]] = true
+	case *parse.IfNode:
+		promptFields(n.Pipe, fields)
+		promptFields(n.List, fields)
+		promptFields(n.ElseList, fields)
+	case *parse.RangeNode:
+		promptFields(n.Pipe, fields)
+		promptFields(n.List, fields)
+		promptFields(n.ElseList, fields)
+	case *parse.WithNode:
+		promptFields(n.Pipe, fields)
+		promptFields(n.List, fields)
+		promptFields(n.ElseList, fields)
+	}
+}
+
+func validatePrompt(t *template.Template) error {
+	fields := make(map[string]bool)
+	promptFields(t.Tree.Root, fields)
+	for _, slot := range requiredPromptSlots {
+		if !fields[slot] {
+			return fmt.Errorf("missing slot {{.%s}}", slot)
+		}
+		delete(fields, slot)
+	}
+	for _, slot := range optionalPromptSlots {
+		delete(fields, slot)
+	}
+	for field := range fields {
+		return fmt.Errorf("unknown slot {{.%s}}", field)
+	}
+	return nil
+}
+
+// loadPrompts parses and validates every prompts/{version}.tmpl. It's called for every question (like getSettings),
+// so prompt edits take effect without a restart.
+func loadPrompts() map[string]*template.Template {
+	prompts := make(map[string]*template.Template)
+	for _, path := range unwrap(filepath.Glob(filepath.Join(promptDir, "*.tmpl"))) {
+		version := strings.TrimSuffix(filepath.Base(path), ".tmpl")
+		t, err := template.New(version).Option("missingkey=error").Parse(readFile(path))
+		if err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+		if err := validatePrompt(t); err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+		prompts[version] = t
+	}
+	return prompts
+}
+
+func promptTemplate(version string) *template.Template {
+	t, ok := loadPrompts()[version]
+	if !ok {
+		log.Fatalf("Prompt version '%s' does not exist (there is no %s)", version,
+			filepath.Join(promptDir, version+".tmpl"))
+	}
+	return t
+}
+
+// compilePrompt fills in a prompt, for an answer in language (an ISO 639-1 code)
+func compilePrompt(version string, history []string, question string, language string) string {
+	var sb strings.Builder
+	fail(promptTemplate(version).Execute(&sb, promptSlots{
+		Resume:   resume(),
+		Facts:    strings.Join(facts, "\n"),
+		History:  strings.Join(history, "\n"),
+		Question: question,
+		Language: languageNames[language],
+	}))
+	return sb.String()
+}
+
+// promptInstructions is a prompt without any of its slots filled in: just the instructions to the model, which it
+// shouldn't repeat (see leakGuardConfig)
+func promptInstructions(version string) string {
+	var sb strings.Builder
+	fail(promptTemplate(version).Execute(&sb, promptSlots{}))
+	return sb.String()
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+	"text/template"
+)
+
+func TestValidatePrompt(t *testing.T) {
+
+	for _, prompt := range loadPrompts() {
+		testAssert(t, validatePrompt(prompt) == nil)
+	}
+
+	valid := template.Must(template.New("valid").Parse(
+		"{{.Resume}} {{.Facts}} {{if .History}}{{.History}}{{end}} {{.Question}}"))
+	testAssert(t, validatePrompt(valid) == nil)
+
+	withLanguage := template.Must(template.New("withLanguage").Parse(
+		"{{.Resume}} {{.Facts}} {{.History}} {{.Question}} Answer in {{.Language}}."))
+	testAssert(t, validatePrompt(withLanguage) == nil)
+
+	missingHistory := template.Must(template.New("missingHistory").Parse(
+		"{{.Resume}} {{.Facts}} {{.Question}}"))
+	testAssert(t, validatePrompt(missingHistory) != nil)
+
+	unknownSlot := template.Must(template.New("unknownSlot").Parse(
+		"{{.Resume}} {{.Facts}} {{.History}} {{.Question}} {{.Salary}}"))
+	testAssert(t, validatePrompt(unknownSlot) != nil)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// provider is the part of *openai.Client that answerQuestion needs. Anything that implements it (e.g., the cassette
+// recorder and replayer in cassette.go) can stand in for OpenAI.
+type provider interface {
+	CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+}
+
+const providerSpecUsage = `openai, fake, local=<base URL of an OpenAI-compatible server>, replay=<cassette>, or record=<cassette>`
+
+// providerFromSpec turns a command-line provider spec (see providerSpecUsage) into a provider. "fake" returns nil,
+// which makes answerQuestion use its false responses.
+func providerFromSpec(spec string) provider {
+	kind, arg, hasArg := strings.Cut(spec, "=")
+	if hasArg != (kind == "local" || kind == "replay" || kind == "record") || (hasArg && arg == "") {
+		log.Fatalf("Invalid provider '%s'; should be one of: %s", spec, providerSpecUsage)
+	}
+	switch kind {
+	case "openai":
+		return initializeClient()
+	case "fake":
+		return nil
+	case "local":
+		// Local servers (llama.cpp, Ollama, etc.) don't check the key, but go-openai insists on sending one
+		config := openai.DefaultConfig("local")
+		config.BaseURL = arg
+		return openai.NewClientWithConfig(config)
+	case "replay":
+		return newReplayer(arg)
+	case "record":
+		return newRecorder(initializeClient(), arg)
+	}
+	log.Fatalf("Invalid provider '%s'; should be one of: %s", spec, providerSpecUsage)
+	return nil
+}
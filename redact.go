@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Without this file, nothing is redacted
+const redactionFile = "redaction.yaml"
+
+type redactor struct {
+	kind    string
+	pattern *regexp.Regexp
+	// valid rules out false positives that the pattern can't (e.g., digit runs that fail the Luhn check)
+	valid func(match string) bool
+}
+
+type redactionConfig struct {
+	Redact []string `yaml:"redact"`
+	Custom []struct {
+		Kind    string `yaml:"kind"`
+		Pattern string `yaml:"pattern"`
+	} `yaml:"custom"`
+}
+
+// builtinRedactors run in this order, so that e.g. a credit card number is never mistaken for a phone number
+var builtinRedactors = []redactor{
+	{"email", emailPattern, nil},
+	{"credit-card", regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), luhn},
+	{"phone", phonePattern, nil},
+	// Street names are capitalized (or ordinals, like 5th), which keeps e.g. "3 years at the first place" or "2 years
+	// in the court system" from looking like addresses
+	{"street-address", regexp.MustCompile(`\b\d{1,6}[A-Za-z]?\s+(?:(?:[A-Z][A-Za-z.'-]*|\d+(?:st|nd|rd|th))\s+){1,4}` +
+		`(?i:street|st|avenue|ave|road|rd|boulevard|blvd|lane|ln|drive|dr|court|ct|way|place|pl|terrace|circle|cir)\b\.?` +
+		`(?i:,?\s+(?:apt|apartment|suite|ste|unit|#)\.?\s*[a-z0-9-]+)?`), nil},
+}
+
+func luhn(match string) bool {
+	sum, double := 0, false
+	for i := len(match) - 1; i >= 0; i-- {
+		if match[i] < '0' || match[i] > '9' {
+			continue
+		}
+		d := int(match[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// loadRedactors reads redaction.yaml (see redaction.example.yaml), which lists the built-in kinds to redact and any
+// custom patterns, e.g.:
+//
+//	redact: [email, phone, credit-card, street-address]
+//	custom:
+//	  - kind: ssn
+//	    pattern: '\b\d{3}-\d{2}-\d{4}\b'
+func loadRedactors() []redactor {
+	if !fileExists(redactionFile) {
+		return nil
+	}
+	var config redactionConfig
+	decoder := yaml.NewDecoder(strings.NewReader(readFile(redactionFile)))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil {
+		log.Fatalf("%s: %v", redactionFile, err)
+	}
+
+	enabled := make(map[string]bool)
+	for _, kind := range config.Redact {
+		enabled[kind] = true
+	}
+	var redactors []redactor
+	for _, r := range builtinRedactors {
+		if enabled[r.kind] {
+			redactors = append(redactors, r)
+			delete(enabled, r.kind)
+		}
+	}
+	for kind := range enabled {
+		log.Fatalf("%s: Invalid kind '%s'", redactionFile, kind)
+	}
+	for _, custom := range config.Custom {
+		pattern, err := regexp.Compile(custom.Pattern)
+		if err != nil || custom.Kind == "" {
+			log.Fatalf("%s: Invalid custom pattern '%s' for kind '%s': %v", redactionFile, custom.Pattern, custom.Kind, err)
+		}
+		redactors = append(redactors, redactor{custom.Kind, pattern, nil})
+	}
+	return redactors
+}
+
+type redactedValue struct {
+	kind        string
+	placeholder string
+	value       string
+}
+
+// redact replaces PII in text with placeholders like [EMAIL_1]. The same value always gets the same placeholder, so
+// the model can still tell that two mentions are the same thing.
+func redact(redactors []redactor, text string) (string, []redactedValue) {
+	var redacted []redactedValue
+	for _, r := range redactors {
+		placeholders := make(map[string]string)
+		text = r.pattern.ReplaceAllStringFunc(text, func(match string) string {
+			if r.valid != nil && !r.valid(match) {
+				return match
+			}
+			if placeholder, ok := placeholders[match]; ok {
+				return placeholder
+			}
+			placeholder := fmt.Sprintf("[%s_%d]", strings.ToUpper(strings.ReplaceAll(r.kind, "-", "_")),
+				len(placeholders)+1)
+			placeholders[match] = placeholder
+			redacted = append(redacted, redactedValue{r.kind, placeholder, match})
+			return placeholder
+		})
+	}
+	return text, redacted
+}
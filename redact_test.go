@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+
+	text, redacted := redact(builtinRedactors, "I'm jane@example.com, or (555) 123-4567. Write jane@example.com!")
+	testAssert(t, text == "I'm [EMAIL_1], or [PHONE_1]. Write [EMAIL_1]!")
+	testAssert(t, len(redacted) == 2 && redacted[0].value == "jane@example.com")
+
+	text, _ = redact(builtinRedactors, "My card is 4111 1111 1111 1111, not 4111 1111 1111 1112")
+	testAssert(t, text == "My card is [CREDIT_CARD_1], not 4111 1111 1111 1112")
+
+	text, _ = redact(builtinRedactors, "Send it to 221B Baker Street, Apt 3 please")
+	testAssert(t, strings.Contains(text, "[STREET_ADDRESS_1]") && !strings.Contains(text, "Baker"))
+
+	text, _ = redact(builtinRedactors, "I live at 1600 W 5th Ave. now")
+	testAssert(t, text == "I live at [STREET_ADDRESS_1] now")
+
+	for _, question := range []string{"Did Kris spend 3 years at the first place he worked?",
+		"Kris worked 2 years in the court system"} {
+		text, redacted = redact(builtinRedactors, question)
+		testAssert(t, len(redacted) == 0 && text == question)
+	}
+
+	text, redacted = redact(builtinRedactors, "Does Kris know Go? He has 5 years of experience, right?")
+	testAssert(t, len(redacted) == 0 && text == "Does Kris know Go? He has 5 years of experience, right?")
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// server answers questions over HTTP. A pgx.Conn can't be used concurrently, so requests take turns with it.
+type server struct {
+	mu             sync.Mutex
+	ctx            context.Context
+	conn           *pgx.Conn
+	client         provider
+	trustedProxies []netip.Prefix
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fail(json.NewEncoder(w).Encode(v))
+}
+
+func httpError(w http.ResponseWriter, status int, format string, args ...any) {
+	writeJSON(w, status, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+// isAdmin checks the request's bearer token against ADMIN_TOKEN. Without that file, nobody is an admin.
+func isAdmin(r *http.Request) bool {
+	if !fileExists("ADMIN_TOKEN") {
+		return false
+	}
+	adminToken := strings.TrimRight(readFile("ADMIN_TOKEN"), "\r\n")
+	token, ok := bearerToken(r)
+	return ok && adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+type questionRequest struct {
+	Question string `json:"question"`
+	// Language (an ISO 639-1 code, see languageNames) forces the language of the answers, which is otherwise detected
+	Language string `json:"language"`
+}
+
+// POST /question {"question": ..., "language": ...} -> {"answer": ..., "followUps": [...]}, with the visitor's session
+// token. Unlike in command mode, the ipAddrHash comes from the client's IP address rather than from the frontend (see
+// ipaddr.go).
+func (s *server) handleQuestion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	var req questionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "invalid request: %v", err)
+		return
+	}
+	if _, ok := languageNames[req.Language]; req.Language != "" && !ok {
+		httpError(w, http.StatusBadRequest, "unsupported language '%s'", req.Language)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uuid, err := s.sessionUUID(r)
+	if err != nil {
+		httpError(w, http.StatusUnauthorized, "%v", err)
+		return
+	}
+	hashes, ok := s.requestIPHashes(r)
+	if !ok {
+		httpError(w, http.StatusBadRequest, "unknown client address")
+		return
+	}
+	settings := getSettings() // settings may have changed since the last request
+	// Worked out (and remembered) once, here, since the ban and the subnet rate limit are answered in it too
+	language := visitorLanguage(s.ctx, s.conn, uuid, normalizeInput(req.Question, settings.mapConfusables),
+		req.Language)
+	if _, banned := activeBan(s.ctx, s.conn, hashes.subnet); banned {
+		writeJSON(w, http.StatusOK, answer{Text: localize(language, bannedMessage)})
+		return
+	}
+	if limitMessage, limited := subnetRateLimited(s.ctx, s.conn, settings, hashes.subnet, language); limited {
+		writeJSON(w, http.StatusOK, answer{Text: limitMessage})
+		return
+	}
+	writeJSON(w, http.StatusOK, answerQuestion(uuid, hashes.addr, req.Question, language, settings, s.ctx, s.conn,
+		s.client, __debugModeOff))
+}
+
+type fitRequest struct {
+	JobDescription string `json:"jobDescription"`
+}
+
+// POST /fit {"jobDescription": ...} -> {"report": {...}} (or {"message": ...} without one), with the visitor's session
+// token (see fit.go)
+func (s *server) handleFit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	var req fitRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "invalid request: %v", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uuid, err := s.sessionUUID(r)
+	if err != nil {
+		httpError(w, http.StatusUnauthorized, "%v", err)
+		return
+	}
+	hashes, ok := s.requestIPHashes(r)
+	if !ok {
+		httpError(w, http.StatusBadRequest, "unknown client address")
+		return
+	}
+	if _, banned := activeBan(s.ctx, s.conn, hashes.subnet); banned {
+		writeJSON(w, http.StatusOK, fitResult{Message: bannedMessage})
+		return
+	}
+	writeJSON(w, http.StatusOK, analyzeFit(uuid, hashes.addr, req.JobDescription, getSettings(), s.ctx, s.conn,
+		s.client))
+}
+
+// GET /export?format={jsonl|markdown|html} downloads a visitor's own conversation, with their session token.
+// Exporting a date range (?from=YYYY-MM-DD&to=YYYY-MM-DD) covers every visitor, so it needs the admin token instead.
+func (s *server) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = "html"
+	}
+	contentType, ok := exportFormats[format]
+	if !ok {
+		httpError(w, http.StatusBadRequest, "invalid format '%s'", format)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var messages []message
+	var title, fileName string
+	if q.Get("from") != "" {
+		if !isAdmin(r) {
+			httpError(w, http.StatusUnauthorized, "exporting a date range needs the admin token")
+			return
+		}
+		from, to, err := parseDateRange(q.Get("from"), q.Get("to"))
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+		messages = messagesBetween(s.ctx, s.conn, from, to)
+		title = fmt.Sprintf("portfolio-chatbot conversations from %s to %s", from.Format("2006-01-02"),
+			to.Format("2006-01-02 15:04"))
+		fileName = "conversations"
+	} else {
+		uuid, err := s.sessionUUID(r)
+		if err != nil {
+			httpError(w, http.StatusUnauthorized, "%v", err)
+			return
+		}
+		messages = sessionMessages(s.ctx, s.conn, uuid)
+		title, fileName = "Conversation with portfolio-chatbot", "conversation"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, fileName,
+		exportExtensions[format]))
+	fail(writeExport(w, format, title, messages))
+}
+
+func (s *server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/session", s.handleSession)
+	mux.HandleFunc("/question", s.handleQuestion)
+	mux.HandleFunc("/fit", s.handleFit)
+	mux.HandleFunc("/export", s.handleExport)
+	mux.HandleFunc("/data", s.handleData)
+	return mux
+}
+
+// serveCommand runs the chatbot as an HTTP server:
+//
+//	./portfolio-chatbot serve [-addr localhost:8080] [-provider openai] [-trusted-proxies 127.0.0.1,10.0.0.0/8]
+func serveCommand(args []string, settings settings, ctx context.Context, conn *pgx.Conn) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", "localhost:8080", "address to listen on")
+	providerSpec := flags.String("provider", "openai", "where answers come from: "+providerSpecUsage)
+	trustedProxies := flags.String("trusted-proxies", "",
+		"comma-separated addresses and CIDR ranges of the reverse proxies whose X-Forwarded-For to believe")
+	fail(flags.Parse(args))
+
+	proxies, err := parseTrustedProxies(*trustedProxies)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	s := &server{ctx: ctx, conn: conn, client: providerFromSpec(*providerSpec), trustedProxies: proxies}
+	go deliverNotificationsEvery(ctx, setupDB(ctx), notifyInterval)
+	log.Infof("Listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, s.routes()))
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// A signing key is used for sessionKeyRotation, and verifies tokens for as long as they can live after that
+const (
+	sessionTokenLifetime = 24 * time.Hour
+	sessionKeyRotation   = 24 * time.Hour
+)
+
+// New sessions are limited to sessionLimitCount per IP address (and subnetRateLimitFactor times that per subnet)
+// within sessionLimitDelay, so that a script can't dodge rate limits by starting a new session for every question
+// either
+const (
+	sessionLimitCount = 10
+	sessionLimitDelay = time.Hour
+)
+
+var (
+	errInvalidToken = errors.New("invalid session token")
+	errExpiredToken = errors.New("expired session token")
+)
+
+type sessionToken struct {
+	uuid    string
+	issued  time.Time
+	expires time.Time
+}
+
+// A session token is "{key id}.{uuid}.{issued}.{expires}.{signature}", where the times are Unix timestamps and the
+// signature is an HMAC-SHA256 of everything before it, with the signing key that the key id refers to
+func signSessionToken(keyID int, key []byte, uuid string, issued time.Time) string {
+	payload := fmt.Sprintf("%d.%s.%d.%d", keyID, uuid, issued.Unix(), issued.Add(sessionTokenLifetime).Unix())
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parseSessionToken verifies a session token with the signing keys (by key id) that are still valid
+func parseSessionToken(token string, keys map[int][]byte, now time.Time) (sessionToken, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 {
+		return sessionToken{}, errInvalidToken
+	}
+	keyID, err := strconv.Atoi(parts[0])
+	key, ok := keys[keyID]
+	if err != nil || !ok {
+		return sessionToken{}, errInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[4])
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.Join(parts[:4], ".")))
+	if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+		return sessionToken{}, errInvalidToken
+	}
+
+	issued, err1 := strconv.ParseInt(parts[2], 10, 64)
+	expires, err2 := strconv.ParseInt(parts[3], 10, 64)
+	if err1 != nil || err2 != nil {
+		return sessionToken{}, errInvalidToken
+	}
+	t := sessionToken{parts[1], time.Unix(issued, 0), time.Unix(expires, 0)}
+	if !now.Before(t.expires) {
+		return t, errExpiredToken
+	}
+	return t, nil
+}
+
+// newSessionToken issues a token for a visitor, or for a new visitor if uuid is empty
+func newSessionToken(ctx context.Context, conn *pgx.Conn, uuid_ string) string {
+	if uuid_ == "" {
+		uuid_ = uuid.NewString()
+	}
+	keyID, keys := sessionKeys(ctx, conn, sessionKeyRotation, sessionKeyRotation+sessionTokenLifetime)
+	return signSessionToken(keyID, keys[keyID], uuid_, time.Now())
+}
+
+// sessionLimited checks whether an IP address or its subnet has started too many sessions lately, and if not, counts
+// a new one. It returns how many seconds are left until the next session can start.
+func sessionLimited(ctx context.Context, conn *pgx.Conn, hashes ipHashes) (int, bool) {
+	delay := int(sessionLimitDelay.Milliseconds())
+	limits := map[string]int{"session/" + hashes.addr: sessionLimitCount,
+		"session/" + hashes.subnet: sessionLimitCount * subnetRateLimitFactor}
+	for key, count := range limits {
+		if timeElapsed, limited := rateLimitElapsed(ctx, conn, []string{key}, count, delay); limited {
+			return Ceil((float64(delay) - float64(timeElapsed)) / 1000.0), true
+		}
+	}
+	for key := range limits {
+		resetExpiredRateLimit(ctx, conn, key, delay)
+		incrementRateLimit(ctx, conn, key)
+	}
+	return 0, false
+}
+
+// verifySessionToken returns the uuid of the visitor a token was issued to
+func verifySessionToken(ctx context.Context, conn *pgx.Conn, token string) (string, error) {
+	_, keys := sessionKeys(ctx, conn, sessionKeyRotation, sessionKeyRotation+sessionTokenLifetime)
+	t, err := parseSessionToken(token, keys, time.Now())
+	return t.uuid, err
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	authorization := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	return token, token != authorization && token != ""
+}
+
+// sessionUUID authenticates a visitor by their session token (Authorization: Bearer {token}). It must be called with
+// s.mu held.
+func (s *server) sessionUUID(r *http.Request) (string, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return "", errInvalidToken
+	}
+	return verifySessionToken(s.ctx, s.conn, token)
+}
+
+// POST /session -> {"token": ..., "expires": ...} starts a session, as long as the client hasn't started too many
+// (see sessionLimited). With a valid (unexpired) session token, it continues that session instead, so that active
+// visitors keep their uuid.
+func (s *server) handleSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	uuid, err := s.sessionUUID(r)
+	if _, ok := bearerToken(r); ok && err != nil {
+		httpError(w, http.StatusUnauthorized, "%v", err)
+		return
+	}
+	if uuid == "" {
+		hashes, ok := s.requestIPHashes(r)
+		if !ok {
+			httpError(w, http.StatusBadRequest, "unknown client address")
+			return
+		}
+		if seconds, limited := sessionLimited(s.ctx, s.conn, hashes); limited {
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			httpError(w, http.StatusTooManyRequests, "too many new sessions; try again in %d seconds", seconds)
+			return
+		}
+	}
+	token := newSessionToken(s.ctx, s.conn, uuid)
+	writeJSON(w, http.StatusOK, map[string]any{"token": token, "expires": time.Now().Add(sessionTokenLifetime)})
+}
+
+// sessionCommand issues session tokens for frontends that use command mode:
+//
+//	./portfolio-chatbot session [token]
+func sessionCommand(args []string, settings settings, ctx context.Context, conn *pgx.Conn) {
+	uuid := ""
+	if len(args) == 1 {
+		var err error
+		if uuid, err = verifySessionToken(ctx, conn, args[0]); err != nil {
+			log.Fatalf("%v", err)
+		}
+	} else if len(args) > 1 {
+		fmt.Println("Error: Wrong format: Should be ./portfolio-chatbot session [token].")
+		return
+	}
+	fmt.Println(newSessionToken(ctx, conn, uuid))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestSessionTokens(t *testing.T) {
+
+	key, oldKey := randomSecret(), randomSecret()
+	keys := map[int][]byte{1: oldKey, 2: key}
+	issued := time.Now()
+	token := signSessionToken(2, key, "u", issued)
+
+	parsed, err := parseSessionToken(token, keys, issued.Add(time.Hour))
+	testAssert(t, err == nil && parsed.uuid == "u")
+
+	// Tokens signed with an older key stay valid for as long as that key is kept
+	_, err = parseSessionToken(signSessionToken(1, oldKey, "u", issued), keys, issued)
+	testAssert(t, err == nil)
+
+	_, err = parseSessionToken(token, keys, issued.Add(sessionTokenLifetime))
+	testAssert(t, err == errExpiredToken)
+
+	// A visitor can't switch to another uuid, extend their token, or use a key that has been rotated out
+	parts := strings.Split(token, ".")
+	for _, forged := range []string{
+		strings.Replace(token, ".u.", ".v.", 1),
+		strings.Join([]string{parts[0], parts[1], parts[2], "99999999999", parts[4]}, "."),
+		signSessionToken(2, randomSecret(), "u", issued),
+		signSessionToken(3, key, "u", issued),
+		"u",
+		"",
+	} {
+		_, err = parseSessionToken(forged, keys, issued)
+		testAssert(t, err == errInvalidToken)
+	}
+}
+
+func TestSessionLimit(t *testing.T) {
+
+	ctx := context.Background()
+	conn := setupDB(ctx)
+	defer conn.Close(ctx)
+
+	// An IP address can only start so many sessions...
+	subnet := uuid.NewString()
+	hashes := ipHashes{uuid.NewString(), subnet}
+	for i := 0; i < sessionLimitCount; i++ {
+		_, limited := sessionLimited(ctx, conn, hashes)
+		testAssert(t, !limited)
+	}
+	seconds, limited := sessionLimited(ctx, conn, hashes)
+	testAssert(t, limited && seconds > 0 && seconds <= int(sessionLimitDelay.Seconds()))
+
+	// ...but other addresses in its subnet can still start their own
+	_, limited = sessionLimited(ctx, conn, ipHashes{uuid.NewString(), subnet})
+	testAssert(t, !limited)
+}
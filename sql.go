@@ -1,6 +1,13 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
 	"github.com/jackc/pgx/v5"
 )
 
@@ -8,3 +15,652 @@ func finishRows(rows pgx.Rows) {
 	rows.Close()
 	fail(rows.Err())
 }
+
+// A message is a row of message_queue. text starts with "USER: " or "AI: ".
+type message struct {
+	id               int
+	uuid             string
+	text             string
+	arm              string
+	model            string
+	promptTokens     int
+	completionTokens int
+	timestamp        time.Time
+}
+
+func (m message) isAnswer() bool {
+	return strings.HasPrefix(m.text, "AI: ")
+}
+
+// sealMessage encrypts a message's text if there is an encryption key (see crypto.go and sealText). The "USER: " or
+// "AI: " prefix stays in plaintext, so that e.g. usageSince can still count questions and answers.
+func sealMessage(key []byte, text string) string {
+	role, content, ok := strings.Cut(text, ": ")
+	if !ok {
+		return text
+	}
+	return role + ": " + sealText(key, content)
+}
+
+func unsealMessage(text string, keys ...[]byte) string {
+	return unwrap(openMessage(text, keys...))
+}
+
+// openMessage is unsealMessage for messages that may have been sealed with a key that is gone
+func openMessage(text string, keys ...[]byte) (string, error) {
+	role, content, ok := strings.Cut(text, ": ")
+	if !ok {
+		return text, nil
+	}
+	content, err := openText(content, keys...)
+	return role + ": " + content, err
+}
+
+func insertMessage(ctx context.Context, conn *pgx.Conn, m message) {
+	unwrap(conn.Exec(ctx, `INSERT INTO message_queue (uuid, message, arm, model, prompt_tokens, completion_tokens)
+												 VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5, $6)`,
+		m.uuid, sealMessage(masterKey(), m.text), m.arm, m.model, m.promptTokens, m.completionTokens))
+}
+
+const messageColumns = `id, uuid, message, COALESCE(arm, ''), COALESCE(model, ''), COALESCE(prompt_tokens, 0),
+												COALESCE(completion_tokens, 0), timestamp_`
+
+func scanMessages(rows pgx.Rows) []message {
+	defer finishRows(rows)
+	key := masterKey()
+	var messages []message
+	for rows.Next() {
+		var m message
+		fail(rows.Scan(&m.id, &m.uuid, &m.text, &m.arm, &m.model, &m.promptTokens, &m.completionTokens, &m.timestamp))
+		m.text = unsealMessage(m.text, key)
+		messages = append(messages, m)
+	}
+	return messages
+}
+
+// lastQuestion returns a visitor's last question, if they asked it within the given time
+func lastQuestion(ctx context.Context, conn *pgx.Conn, uuid string, within time.Duration) (string, bool) {
+	var text string
+	err := conn.QueryRow(ctx, `SELECT message
+														 FROM message_queue
+														 WHERE uuid = $1
+														 AND message LIKE 'USER: %'
+														 AND timestamp_ > current_timestamp - $2 * INTERVAL '1 second'
+														 ORDER BY timestamp_ DESC, id DESC
+														 LIMIT 1`, uuid, within.Seconds()).Scan(&text)
+	if err == pgx.ErrNoRows {
+		return "", false
+	}
+	fail(err)
+	return strings.TrimPrefix(unsealMessage(text, masterKey()), "USER: "), true
+}
+
+// sessionMessages returns a visitor's messages, oldest first
+func sessionMessages(ctx context.Context, conn *pgx.Conn, uuid string) []message {
+	return scanMessages(unwrap(conn.Query(ctx, `SELECT `+messageColumns+`
+																							FROM message_queue
+																							WHERE uuid = $1
+																							ORDER BY timestamp_ ASC, id ASC`, uuid)))
+}
+
+// messagesBetween returns every message in [from, to), oldest first
+func messagesBetween(ctx context.Context, conn *pgx.Conn, from, to time.Time) []message {
+	return scanMessages(unwrap(conn.Query(ctx, `SELECT `+messageColumns+`
+																							FROM message_queue
+																							WHERE timestamp_ >= $1 AND timestamp_ < $2
+																							ORDER BY timestamp_ ASC, id ASC`, from, to)))
+}
+
+func deleteOldestMessage(ctx context.Context, conn *pgx.Conn, uuid string) {
+	unwrap(conn.Exec(ctx, `DELETE FROM message_queue
+												 WHERE id = (
+													 SELECT id
+													 FROM message_queue
+													 WHERE uuid = $1
+													 ORDER BY timestamp_ ASC
+													 LIMIT 1
+												 )`, uuid))
+}
+
+// gcMessages deletes a visitor's messages that are older than maxAge milliseconds
+func gcMessages(ctx context.Context, conn *pgx.Conn, uuid string, maxAge int) {
+	unwrap(conn.Exec(ctx, `DELETE FROM message_queue
+												 WHERE id IN (
+														 SELECT id
+														 FROM message_queue
+														 WHERE uuid = (
+															 SELECT uuid
+															 FROM last_activity
+															 WHERE uuid = $1
+															 LIMIT 1
+														 )
+														 AND EXTRACT(EPOCH FROM (current_timestamp - timestamp_))*1000 >= $2
+												 )`, uuid, maxAge))
+}
+
+// touchSession records activity for a visitor and returns how many questions they have asked so far
+func touchSession(ctx context.Context, conn *pgx.Conn, uuid, ipAddrHash string) int {
+	var questionCount int
+	fail(conn.QueryRow(ctx, `INSERT INTO last_activity (uuid, ip_addr_hash) VALUES ($1, $2)
+													 ON CONFLICT (uuid)
+													 DO UPDATE SET timestamp_ = DEFAULT, questions = last_activity.questions + 1, ip_addr_hash = $2
+													 RETURNING questions`, uuid, ipAddrHash).Scan(&questionCount))
+	return questionCount
+}
+
+type session struct {
+	uuid         string
+	lastActivity time.Time
+	questions    int
+}
+
+// recentSessions returns the visitors that were active since a point in time, most recent first
+func recentSessions(ctx context.Context, conn *pgx.Conn, since time.Time) []session {
+	rows := unwrap(conn.Query(ctx, `SELECT uuid, timestamp_, questions
+																	FROM last_activity
+																	WHERE timestamp_ >= $1
+																	ORDER BY timestamp_ DESC`, since))
+	defer finishRows(rows)
+	var sessions []session
+	for rows.Next() {
+		var s session
+		fail(rows.Scan(&s.uuid, &s.lastActivity, &s.questions))
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// rateLimitElapsed checks whether any of the keys has reached the rate limit, and if so, how many milliseconds ago
+func rateLimitElapsed(ctx context.Context, conn *pgx.Conn, keys []string, count, delay int) (int, bool) {
+	rows := unwrap(conn.Query(ctx, `SELECT (EXTRACT(EPOCH FROM (current_timestamp - timestamp_)) * 1000)::INT
+																	FROM ratelimit
+																	WHERE key = ANY($1)
+																	AND count >= $2
+																	AND EXTRACT(EPOCH FROM (current_timestamp - timestamp_))*1000 < $3`,
+		keys, count, delay))
+	defer finishRows(rows)
+
+	if rows.Next() {
+		var timeElapsed int
+		fail(rows.Scan(&timeElapsed))
+		return timeElapsed, true
+	}
+	return 0, false
+}
+
+// resetExpiredRateLimit starts counting from zero again once a key's last message is more than delay ms old
+func resetExpiredRateLimit(ctx context.Context, conn *pgx.Conn, key string, delay int) {
+	unwrap(conn.Exec(ctx, `UPDATE ratelimit
+												 SET count = 0
+												 WHERE key = $1
+												 AND count > 1
+												 AND EXTRACT(EPOCH FROM (current_timestamp - timestamp_))*1000 >= $2`, key, delay))
+}
+
+func incrementRateLimit(ctx context.Context, conn *pgx.Conn, key string) {
+	unwrap(conn.Exec(ctx, `INSERT INTO ratelimit (key) VALUES ($1)
+												 ON CONFLICT (key)
+												 DO UPDATE SET count = ratelimit.count + 1, timestamp_ = DEFAULT`, key))
+}
+
+// resetRateLimit forgets a key's rate limit and returns whether there was one
+func resetRateLimit(ctx context.Context, conn *pgx.Conn, key string) bool {
+	return unwrap(conn.Exec(ctx, "DELETE FROM ratelimit WHERE key = $1", key)).RowsAffected() > 0
+}
+
+func recordRateLimitHit(ctx context.Context, conn *pgx.Conn, uuid, arm string) {
+	unwrap(conn.Exec(ctx, "INSERT INTO ratelimit_hits (uuid, arm) VALUES ($1, $2)", uuid, arm))
+}
+
+// visitorTables are the tables that hold data about visitors, with the condition that selects the rows of the
+// visitors $1 (uuids) whose ipAddrHash is $2 (which may be empty). Bans aren't here, since erasing them would lift
+// them; abuse events expire within an hour.
+var visitorTables = [][2]string{
+	{"message_queue", "uuid = ANY($1)"},
+	{"last_activity", "uuid = ANY($1)"},
+	{"ratelimit", "key = ANY($1) OR key = $2 OR key = ANY(SELECT 'fit/' || u FROM unnest($1::TEXT[]) u) OR " +
+		"key = 'fit/' || $2 OR key = 'session/' || $2"},
+	{"ratelimit_hits", "uuid = ANY($1)"},
+	{"feedback", "uuid = ANY($1)"},
+	{"answer_usage", "uuid = ANY($1)"},
+	{"leads", "uuid = ANY($1)"},
+	{"notifications", "uuid = ANY($1)"},
+	{"guard_incidents", "uuid = ANY($1)"},
+	{"session_languages", "uuid = ANY($1)"},
+	{"fit_analyses", "uuid = ANY($1)"},
+	{"ip_links", "hash = $2"},
+}
+
+// visitorsByIpAddrHash returns the uuids of the visitors that last asked a question from an ipAddrHash
+func visitorsByIpAddrHash(ctx context.Context, conn *pgx.Conn, ipAddrHash string) []string {
+	rows := unwrap(conn.Query(ctx, "SELECT uuid FROM last_activity WHERE ip_addr_hash = $1", ipAddrHash))
+	defer finishRows(rows)
+	var uuids []string
+	for rows.Next() {
+		var uuid string
+		fail(rows.Scan(&uuid))
+		uuids = append(uuids, uuid)
+	}
+	return uuids
+}
+
+// visitorRows returns the rows of every visitor table that belong to the visitors, as JSON objects
+func visitorRows(ctx context.Context, conn *pgx.Conn, uuids []string, ipAddrHash string) map[string][]json.RawMessage {
+	tables := make(map[string][]json.RawMessage)
+	for _, table := range visitorTables {
+		rows := unwrap(conn.Query(ctx, "SELECT row_to_json(t)::TEXT FROM "+table[0]+" t WHERE "+table[1],
+			uuids, ipAddrHash))
+		tables[table[0]] = []json.RawMessage{}
+		for rows.Next() {
+			var row string
+			fail(rows.Scan(&row))
+			tables[table[0]] = append(tables[table[0]], json.RawMessage(row))
+		}
+		finishRows(rows)
+	}
+	return tables
+}
+
+// eraseVisitors deletes everything about the visitors in a single transaction, together with an audit record that
+// only keeps a hash of the subject. It returns the number of deleted rows per table.
+func eraseVisitors(ctx context.Context, conn *pgx.Conn, uuids []string, ipAddrHash string, subjectKind, subject,
+	requestedBy string) map[string]int64 {
+
+	deleted := make(map[string]int64)
+	fail(pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		for _, table := range visitorTables {
+			tag, err := tx.Exec(ctx, "DELETE FROM "+table[0]+" WHERE "+table[1], uuids, ipAddrHash)
+			if err != nil {
+				return err
+			}
+			deleted[table[0]] = tag.RowsAffected()
+		}
+		subjectHash := sha256.Sum256([]byte(subject))
+		_, err := tx.Exec(ctx, `INSERT INTO erasures (subject_kind, subject_hash, requested_by, deleted)
+														VALUES ($1, $2, $3, $4)`,
+			subjectKind, hex.EncodeToString(subjectHash[:]), requestedBy, string(unwrap(json.Marshal(deleted))))
+		return err
+	}))
+	return deleted
+}
+
+// rotateKey re-encrypts conversations, leads, guard incidents, notifications and secrets (session signing keys and IP
+// salts) with a new key in a single transaction: data keys are rewrapped, plaintext rows (from before there was a key)
+// are sealed, and leads get a new blind index. It returns the number of changed rows per table.
+func rotateKey(ctx context.Context, conn *pgx.Conn, newKey []byte, oldKeys ...[]byte) map[string]int64 {
+	rotated := make(map[string]int64)
+	fail(pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		type row struct {
+			id    any // the primary key, which is a key rather than an id in notifications
+			value string
+		}
+		selectRows := func(query string) ([]row, error) {
+			rows, err := tx.Query(ctx, query)
+			if err != nil {
+				return nil, err
+			}
+			defer rows.Close()
+			var result []row
+			for rows.Next() {
+				var r row
+				if err := rows.Scan(&r.id, &r.value); err != nil {
+					return nil, err
+				}
+				result = append(result, r)
+			}
+			return result, rows.Err()
+		}
+
+		messages, err := selectRows("SELECT id, message FROM message_queue")
+		if err != nil {
+			return err
+		}
+		for _, m := range messages {
+			role, content, ok := strings.Cut(m.value, ": ")
+			if !ok || sealedWith(content, newKey) {
+				continue
+			}
+			if isSealed(content) {
+				content, err = rewrap(content, newKey, oldKeys...)
+			} else {
+				content, err = openText(content)
+				content = seal(newKey, content)
+			}
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, "UPDATE message_queue SET message = $1 WHERE id = $2",
+				role+": "+content, m.id); err != nil {
+				return err
+			}
+			rotated["message_queue"]++
+		}
+
+		leads, err := selectRows("SELECT id, value FROM leads")
+		if err != nil {
+			return err
+		}
+		for _, lead := range leads {
+			if sealedWith(lead.value, newKey) {
+				continue
+			}
+			value, err := openText(lead.value, oldKeys...)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, "UPDATE leads SET value = $1, value_hash = $2 WHERE id = $3",
+				seal(newKey, value), blindIndex(newKey, value), lead.id); err != nil {
+				return err
+			}
+			rotated["leads"]++
+		}
+
+		// table, primary key, sealed column
+		tables := [][3]string{{"session_keys", "id", "secret"}, {"ip_salts", "id", "secret"}, {"ban_salt", "id", "secret"},
+			{"guard_incidents", "id", "text"}, {"notifications", "key", "payload"}}
+		for _, table := range tables {
+			values, err := selectRows("SELECT " + table[1] + ", " + table[2] + " FROM " + table[0] + " WHERE " +
+				table[2] + " IS NOT NULL")
+			if err != nil {
+				return err
+			}
+			for _, r := range values {
+				if sealedWith(r.value, newKey) {
+					continue
+				}
+				value := r.value
+				if isSealed(value) {
+					value, err = rewrap(value, newKey, oldKeys...)
+				} else {
+					value, err = openText(value)
+					value = seal(newKey, value)
+				}
+				if err != nil {
+					return err
+				}
+				if _, err := tx.Exec(ctx, "UPDATE "+table[0]+" SET "+table[2]+" = $1 WHERE "+table[1]+" = $2", value,
+					r.id); err != nil {
+					return err
+				}
+				rotated[table[0]]++
+			}
+		}
+		return nil
+	}))
+	return rotated
+}
+
+func logAdminAction(ctx context.Context, conn *pgx.Conn, action, reason string) {
+	unwrap(conn.Exec(ctx, "INSERT INTO admin_log (action, reason) VALUES ($1, $2)", action, reason))
+}
+
+// lastAdminAction returns the most recent of the given admin actions
+func lastAdminAction(ctx context.Context, conn *pgx.Conn, actions ...string) (string, string, time.Time, bool) {
+	var action, reason string
+	var timestamp time.Time
+	err := conn.QueryRow(ctx, `SELECT action, reason, timestamp_
+														 FROM admin_log
+														 WHERE action = ANY($1)
+														 ORDER BY timestamp_ DESC
+														 LIMIT 1`, actions).Scan(&action, &reason, &timestamp)
+	if err == pgx.ErrNoRows {
+		return "", "", time.Time{}, false
+	}
+	fail(err)
+	return action, reason, timestamp, true
+}
+
+// sessionKeys returns the id of the current session signing key (see session.go) and every key that still verifies
+// tokens. It creates a new signing key once the current one is older than rotation, and deletes keys older than
+// maxAge. Keys are stored sealed when there is an encryption key.
+func sessionKeys(ctx context.Context, conn *pgx.Conn, rotation, maxAge time.Duration) (int, map[int][]byte) {
+	master := masterKey()
+	unwrap(conn.Exec(ctx, `INSERT INTO session_keys (secret)
+												 SELECT $1
+												 WHERE NOT EXISTS (SELECT 1 FROM session_keys WHERE timestamp_ > current_timestamp - $2 * INTERVAL '1 second')`,
+		sealSecret(master, randomSecret()), rotation.Seconds()))
+	unwrap(conn.Exec(ctx, "DELETE FROM session_keys WHERE timestamp_ <= current_timestamp - $1 * INTERVAL '1 second'",
+		maxAge.Seconds()))
+
+	rows := unwrap(conn.Query(ctx, "SELECT id, secret FROM session_keys ORDER BY timestamp_ ASC, id ASC"))
+	defer finishRows(rows)
+	current := 0
+	keys := make(map[int][]byte)
+	for rows.Next() {
+		var secret string
+		fail(rows.Scan(&current, &secret))
+		keys[current] = unsealSecret(secret, master)
+	}
+	return current, keys
+}
+
+// ipSalt returns the salt for hashing IP addresses on a day (see ipaddr.go), creating it if needed. Older salts are
+// deleted (along with the links of the hashes they made, see linkBanKeys), so that nobody (including us) can hash an
+// address to compare it with older hashes.
+func ipSalt(ctx context.Context, conn *pgx.Conn, now time.Time) []byte {
+	master := masterKey()
+	day := now.UTC().Format("2006-01-02")
+	unwrap(conn.Exec(ctx, "DELETE FROM ip_salts WHERE day < $1::DATE", day))
+	unwrap(conn.Exec(ctx, "DELETE FROM ip_links WHERE day < $1::DATE", day))
+	unwrap(conn.Exec(ctx, "INSERT INTO ip_salts (day, secret) VALUES ($1::DATE, $2) ON CONFLICT (day) DO NOTHING",
+		day, sealSecret(master, randomSecret())))
+	var secret string
+	fail(conn.QueryRow(ctx, "SELECT secret FROM ip_salts WHERE day = $1::DATE", day).Scan(&secret))
+	return unsealSecret(secret, master)
+}
+
+// banSalt returns the salt for hashing the IP addresses that bans are keyed by, creating it if needed. Unlike ipSalt,
+// it never changes, so that bans on an IP address or subnet last past midnight.
+func banSalt(ctx context.Context, conn *pgx.Conn) []byte {
+	master := masterKey()
+	unwrap(conn.Exec(ctx, "INSERT INTO ban_salt (id, secret) VALUES (1, $1) ON CONFLICT (id) DO NOTHING",
+		sealSecret(master, randomSecret())))
+	var secret string
+	fail(conn.QueryRow(ctx, "SELECT secret FROM ban_salt WHERE id = 1").Scan(&secret))
+	return unsealSecret(secret, master)
+}
+
+// linkBanKeys records which ban key (a hash with banSalt) each of today's IP hashes stands for, so that bans on an
+// ipAddrHash or subnet hash are stored under, and checked against, their ban key
+func linkBanKeys(ctx context.Context, conn *pgx.Conn, now time.Time, links map[string]string) {
+	for hash, banKey := range links {
+		unwrap(conn.Exec(ctx, `INSERT INTO ip_links (hash, ban_key, day) VALUES ($1, $2, $3::DATE)
+													 ON CONFLICT (hash) DO NOTHING`, hash, banKey, now.UTC().Format("2006-01-02")))
+	}
+}
+
+type ban struct {
+	key       string
+	reason    string
+	createdBy string
+	expires   *time.Time // nil for a permanent ban
+	timestamp time.Time
+}
+
+// addBan bans a key until expires, or forever if expires is the zero time
+func addBan(ctx context.Context, conn *pgx.Conn, key, reason, createdBy string, expires time.Time) {
+	var expiresAt *time.Time
+	if !expires.IsZero() {
+		expiresAt = &expires
+	}
+	unwrap(conn.Exec(ctx, `INSERT INTO bans (key, reason, created_by, expires)
+												 VALUES (COALESCE((SELECT ban_key FROM ip_links WHERE hash = $1), $1), $2, $3, $4)`,
+		key, reason, createdBy, expiresAt))
+}
+
+const banColumns = "key, reason, created_by, expires, timestamp_"
+
+func scanBans(rows pgx.Rows) []ban {
+	defer finishRows(rows)
+	var bans []ban
+	for rows.Next() {
+		var b ban
+		fail(rows.Scan(&b.key, &b.reason, &b.createdBy, &b.expires, &b.timestamp))
+		bans = append(bans, b)
+	}
+	return bans
+}
+
+// activeBan returns the ban of any of the keys (or of their ban keys, see linkBanKeys) that is in effect, if there is
+// one
+func activeBan(ctx context.Context, conn *pgx.Conn, keys ...string) (ban, bool) {
+	bans := scanBans(unwrap(conn.Query(ctx, `SELECT `+banColumns+`
+																					 FROM bans
+																					 WHERE key = ANY(SELECT COALESCE(l.ban_key, k)
+																													 FROM unnest($1::TEXT[]) k
+																													 LEFT JOIN ip_links l ON l.hash = k)
+																					 AND (expires IS NULL OR expires > current_timestamp)
+																					 ORDER BY expires DESC NULLS FIRST
+																					 LIMIT 1`, keys)))
+	if len(bans) == 0 {
+		return ban{}, false
+	}
+	return bans[0], true
+}
+
+// listBans returns the bans in effect, or every ban (including expired and lifted ones), newest first
+func listBans(ctx context.Context, conn *pgx.Conn, all bool) []ban {
+	return scanBans(unwrap(conn.Query(ctx, `SELECT `+banColumns+`
+																					FROM bans
+																					WHERE $1 OR expires IS NULL OR expires > current_timestamp
+																					ORDER BY timestamp_ DESC`, all)))
+}
+
+// liftBan ends a key's bans (they're kept, as expired, for the record) and returns how many there were
+func liftBan(ctx context.Context, conn *pgx.Conn, key string) int64 {
+	return unwrap(conn.Exec(ctx, `UPDATE bans
+																SET expires = current_timestamp
+																WHERE key = COALESCE((SELECT ban_key FROM ip_links WHERE hash = $1), $1)
+																AND (expires IS NULL OR expires > current_timestamp)`, key)).RowsAffected()
+}
+
+// addAbuseEvent records an abuse event for a key and returns the key's abuse score: the points of its events within
+// the window. Older events are deleted.
+func addAbuseEvent(ctx context.Context, conn *pgx.Conn, key, event string, points int, window time.Duration) int {
+	unwrap(conn.Exec(ctx, "DELETE FROM abuse_events WHERE timestamp_ <= current_timestamp - $1 * INTERVAL '1 second'",
+		window.Seconds()))
+	unwrap(conn.Exec(ctx, "INSERT INTO abuse_events (key, event, points) VALUES ($1, $2, $3)", key, event, points))
+	var score int
+	fail(conn.QueryRow(ctx, "SELECT COALESCE(sum(points), 0) FROM abuse_events WHERE key = $1", key).Scan(&score))
+	return score
+}
+
+// recordGuardIncident records that a guard (see guard.go) stepped in, with the rule that triggered it and the text it
+// looked at, which is sealed if there is an encryption key
+func recordGuardIncident(ctx context.Context, conn *pgx.Conn, uuid, guard, rule, text string) {
+	unwrap(conn.Exec(ctx, "INSERT INTO guard_incidents (uuid, guard, rule, text) VALUES ($1, $2, $3, $4)",
+		uuid, guard, rule, sealText(masterKey(), text)))
+}
+
+// sessionLanguage returns the language a visitor was last answered in, or "" (see visitorLanguage)
+func sessionLanguage(ctx context.Context, conn *pgx.Conn, uuid string) string {
+	var language string
+	err := conn.QueryRow(ctx, "SELECT language FROM session_languages WHERE uuid = $1", uuid).Scan(&language)
+	if err == pgx.ErrNoRows {
+		return ""
+	}
+	fail(err)
+	return language
+}
+
+func setSessionLanguage(ctx context.Context, conn *pgx.Conn, uuid, language string) {
+	unwrap(conn.Exec(ctx, `INSERT INTO session_languages (uuid, language) VALUES ($1, $2)
+												 ON CONFLICT (uuid) DO UPDATE SET language = $2, timestamp_ = DEFAULT`, uuid, language))
+}
+
+// recordAnswerUsage records what an answer cost, and its length. Unlike its message, this isn't pruned, so that the
+// report (see experiment.go) covers every answer.
+func recordAnswerUsage(ctx context.Context, conn *pgx.Conn, m message, length int) {
+	unwrap(conn.Exec(ctx, `INSERT INTO answer_usage (uuid, arm, model, length, prompt_tokens, completion_tokens)
+												 VALUES ($1, $2, $3, $4, $5, $6)`, m.uuid, m.arm, m.model, length, m.promptTokens,
+		m.completionTokens))
+}
+
+// latestArm returns the arm of the experiment that a visitor's latest message was in
+func latestArm(ctx context.Context, conn *pgx.Conn, uuid string) (string, bool) {
+	var arm string
+	err := conn.QueryRow(ctx, `SELECT arm FROM (SELECT arm, timestamp_ FROM message_queue WHERE uuid = $1
+																							UNION ALL
+																							SELECT arm, timestamp_ FROM answer_usage WHERE uuid = $1) m
+														 WHERE arm != ''
+														 ORDER BY timestamp_ DESC
+														 LIMIT 1`, uuid).Scan(&arm)
+	if err == pgx.ErrNoRows {
+		return "", false
+	}
+	fail(err)
+	return arm, true
+}
+
+// recordFitAnalysis records what a fit analysis cost. The job description isn't stored.
+func recordFitAnalysis(ctx context.Context, conn *pgx.Conn, uuid, model string, promptTokens, completionTokens int) {
+	unwrap(conn.Exec(ctx, `INSERT INTO fit_analyses (uuid, model, prompt_tokens, completion_tokens)
+												 VALUES ($1, $2, $3, $4)`, uuid, model, promptTokens, completionTokens))
+}
+
+// cachedAnswer returns the cached answer for a key, unless it's older than ttl or can't be unsealed (in which case
+// it's deleted), and counts the hit or miss
+func cachedAnswer(ctx context.Context, conn *pgx.Conn, key string, ttl time.Duration) (string, bool) {
+	unwrap(conn.Exec(ctx, "DELETE FROM answer_cache WHERE timestamp_ <= current_timestamp - $1 * INTERVAL '1 second'",
+		ttl.Seconds()))
+	var answer string
+	err := conn.QueryRow(ctx, "SELECT answer FROM answer_cache WHERE key = $1", key).Scan(&answer)
+	if err != nil && err != pgx.ErrNoRows {
+		panic(err)
+	}
+	if err == nil {
+		// After a key rotation, old answers are as good as expired
+		if answer, err = openText(answer, masterKey()); err != nil {
+			unwrap(conn.Exec(ctx, "DELETE FROM answer_cache WHERE key = $1", key))
+		}
+	}
+	hit := err == nil
+	unwrap(conn.Exec(ctx, "INSERT INTO answer_cache_lookups (hit) VALUES ($1)", hit))
+	if !hit {
+		return "", false
+	}
+	return answer, true
+}
+
+// cacheAnswer caches an answer, sealed if there is an encryption key
+func cacheAnswer(ctx context.Context, conn *pgx.Conn, key, answer string) {
+	unwrap(conn.Exec(ctx, `INSERT INTO answer_cache (key, answer) VALUES ($1, $2)
+												 ON CONFLICT (key) DO UPDATE SET answer = $2, timestamp_ = DEFAULT`, key,
+		sealText(masterKey(), answer)))
+}
+
+type usage struct {
+	sessions         int
+	questions        int
+	answers          int
+	promptTokens     int
+	completionTokens int
+	rateLimitHits    int
+	leads            int
+	cacheHits        int
+	cacheMisses      int
+	// Fit analyses (see fit.go) are counted apart from questions and answers
+	fitAnalyses         int
+	fitPromptTokens     int
+	fitCompletionTokens int
+}
+
+func usageSince(ctx context.Context, conn *pgx.Conn, since time.Time) usage {
+	var u usage
+	fail(conn.QueryRow(ctx, `SELECT
+														 (SELECT count(*) FROM last_activity WHERE timestamp_ >= $1),
+														 (SELECT count(*) FROM message_queue WHERE timestamp_ >= $1 AND message LIKE 'USER: %'),
+														 (SELECT count(*) FROM message_queue WHERE timestamp_ >= $1 AND message LIKE 'AI: %'),
+														 (SELECT COALESCE(sum(prompt_tokens), 0) FROM message_queue WHERE timestamp_ >= $1),
+														 (SELECT COALESCE(sum(completion_tokens), 0) FROM message_queue WHERE timestamp_ >= $1),
+														 (SELECT count(*) FROM ratelimit_hits WHERE timestamp_ >= $1),
+														 (SELECT count(*) FROM leads WHERE timestamp_ >= $1),
+														 (SELECT count(*) FROM answer_cache_lookups WHERE timestamp_ >= $1 AND hit),
+														 (SELECT count(*) FROM answer_cache_lookups WHERE timestamp_ >= $1 AND NOT hit),
+														 (SELECT count(*) FROM fit_analyses WHERE timestamp_ >= $1),
+														 (SELECT COALESCE(sum(prompt_tokens), 0) FROM fit_analyses WHERE timestamp_ >= $1),
+														 (SELECT COALESCE(sum(completion_tokens), 0) FROM fit_analyses WHERE timestamp_ >= $1)`,
+		since).Scan(&u.sessions, &u.questions, &u.answers, &u.promptTokens, &u.completionTokens, &u.rateLimitHits,
+		&u.leads, &u.cacheHits, &u.cacheMisses, &u.fitAnalyses, &u.fitPromptTokens, &u.fitCompletionTokens))
+	return u
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	"gopkg.in/yaml.v3"
+)
+
+// Without this file, the model only has the prompt to go on
+const careerFile = "career.yaml"
+
+// The model gets this many rounds of tool calls before it has to answer
+const maxToolRounds = 4
+
+// Dates are "YYYY-MM"; an employer or school without an end date is the current one
+type employer struct {
+	Name       string   `yaml:"name"`
+	Role       string   `yaml:"role"`
+	Start      string   `yaml:"start"`
+	End        string   `yaml:"end"`
+	Location   string   `yaml:"location"`
+	Highlights []string `yaml:"highlights"`
+}
+
+type school struct {
+	Name    string `yaml:"name"`
+	Program string `yaml:"program"`
+	Start   string `yaml:"start"`
+	End     string `yaml:"end"`
+}
+
+type skill struct {
+	Name     string `yaml:"name" json:"name"`
+	Category string `yaml:"category" json:"category"`
+	// Since is when Kris started using the skill ("YYYY-MM"), which makes for the years of experience
+	Since string `yaml:"since" json:"since"`
+	Level string `yaml:"level" json:"level,omitempty"`
+}
+
+type project struct {
+	Name        string   `yaml:"name" json:"name"`
+	Description string   `yaml:"description" json:"description"`
+	Year        int      `yaml:"year" json:"year"`
+	Skills      []string `yaml:"skills" json:"skills"`
+	URL         string   `yaml:"url" json:"url,omitempty"`
+}
+
+type careerData struct {
+	Employers []employer        `yaml:"employers"`
+	Education []school          `yaml:"education"`
+	Skills    []skill           `yaml:"skills"`
+	Projects  []project         `yaml:"projects"`
+	Contact   map[string]string `yaml:"contact"`
+}
+
+const monthLayout = "2006-01"
+
+// loadCareer reads career.yaml (see career.example.yaml)
+func loadCareer() *careerData {
+	if !fileExists(careerFile) {
+		return nil
+	}
+	var career careerData
+	decoder := yaml.NewDecoder(strings.NewReader(readFile(careerFile)))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&career); err != nil {
+		log.Fatalf("%s: %v", careerFile, err)
+	}
+	var dates []string
+	for _, e := range career.Employers {
+		dates = append(dates, e.Start, e.End)
+	}
+	for _, s := range career.Education {
+		dates = append(dates, s.Start, s.End)
+	}
+	for _, s := range career.Skills {
+		dates = append(dates, s.Since)
+	}
+	for _, date := range dates {
+		if _, err := time.Parse(monthLayout, date); date != "" && err != nil {
+			log.Fatalf("%s: Invalid date '%s'; should be YYYY-MM", careerFile, date)
+		}
+	}
+	return &career
+}
+
+// yearsSince is the time from a "YYYY-MM" date until now, in years (to one decimal)
+func yearsSince(date string, now time.Time) float64 {
+	start, err := time.Parse(monthLayout, date)
+	if err != nil {
+		return 0
+	}
+	months := (now.Year()-start.Year())*12 + int(now.Month()-start.Month())
+	return float64(Max(0, months)*10/12) / 10
+}
+
+// timelineEntry is a job (where name is the employer) or a school (where role is the program)
+type timelineEntry struct {
+	Kind       string   `json:"kind"`
+	Name       string   `json:"name"`
+	Role       string   `json:"role"`
+	Start      string   `json:"start"`
+	End        string   `json:"end,omitempty"`
+	Location   string   `json:"location,omitempty"`
+	Highlights []string `json:"highlights,omitempty"`
+}
+
+// The tools are described to the model with JSON schemas
+func objectSchema(properties map[string]any) json.RawMessage {
+	return unwrap(json.Marshal(map[string]any{"type": "object", "properties": properties}))
+}
+
+var careerTools = []openai.Tool{
+	{Type: openai.ToolTypeFunction, Function: &openai.FunctionDefinition{
+		Name: "get_timeline",
+		Description: "Kris's jobs and education, oldest first, with their dates (YYYY-MM; no end date means current). " +
+			"Use it for questions about where and when Kris worked or studied.",
+		Parameters: objectSchema(map[string]any{"kind": map[string]any{"type": "string",
+			"enum": []string{"all", "work", "education"}}}),
+	}},
+	{Type: openai.ToolTypeFunction, Function: &openai.FunctionDefinition{
+		Name: "get_skills",
+		Description: "Kris's skills, with their category and years of experience. Use it for questions about what " +
+			"Kris knows, and for how long.",
+		Parameters: objectSchema(map[string]any{
+			"name":     map[string]any{"type": "string", "description": "only this skill, e.g. Go"},
+			"category": map[string]any{"type": "string", "description": "only this category, e.g. language"},
+		}),
+	}},
+	{Type: openai.ToolTypeFunction, Function: &openai.FunctionDefinition{
+		Name:        "get_projects",
+		Description: "Kris's projects, newest first.",
+		Parameters: objectSchema(map[string]any{"skill": map[string]any{"type": "string",
+			"description": "only projects that use this skill"}}),
+	}},
+	{Type: openai.ToolTypeFunction, Function: &openai.FunctionDefinition{
+		Name:        "get_contact",
+		Description: "How to contact Kris.",
+		Parameters:  objectSchema(map[string]any{}),
+	}},
+}
+
+func until(end string) string {
+	if end == "" {
+		return "now"
+	}
+	return end
+}
+
+// chunks are the career data as knowledge that answers can cite (see citations.go)
+func (career *careerData) chunks() []chunk {
+	if career == nil {
+		return nil
+	}
+	var chunks []chunk
+	add := func(section, format string, args ...any) {
+		chunks = append(chunks, chunk{section, careerFile, strings.TrimSpace(fmt.Sprintf(format, args...))})
+	}
+	for _, e := range career.Employers {
+		add("Employers", "%s at %s (%s to %s) %s", e.Role, e.Name, e.Start, until(e.End), strings.Join(e.Highlights, " "))
+	}
+	for _, s := range career.Education {
+		add("Education", "%s at %s (%s to %s)", s.Program, s.Name, s.Start, until(s.End))
+	}
+	for _, s := range career.Skills {
+		add("Skills", "%s (%s) since %s %s", s.Name, s.Category, s.Since, s.Level)
+	}
+	for _, p := range career.Projects {
+		add("Projects", "%s (%d): %s Built with %s", p.Name, p.Year, p.Description, strings.Join(p.Skills, ", "))
+	}
+	for kind, value := range career.Contact {
+		add("Contact", "%s: %s", kind, value)
+	}
+	return chunks
+}
+
+// callTool runs a tool call from the model, and returns its result as JSON. Errors are returned to the model too, so
+// it can recover from them.
+func (career careerData) callTool(call openai.ToolCall, now time.Time) string {
+	var args struct {
+		Kind     string `json:"kind"`
+		Name     string `json:"name"`
+		Category string `json:"category"`
+		Skill    string `json:"skill"`
+	}
+	if call.Function.Arguments != "" {
+		if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil {
+			return string(unwrap(json.Marshal(map[string]string{"error": "invalid arguments: " + err.Error()})))
+		}
+	}
+
+	var result any
+	switch call.Function.Name {
+	case "get_timeline":
+		timeline := []timelineEntry{}
+		if args.Kind != "education" {
+			for _, e := range career.Employers {
+				timeline = append(timeline, timelineEntry{"work", e.Name, e.Role, e.Start, e.End, e.Location,
+					e.Highlights})
+			}
+		}
+		if args.Kind != "work" {
+			for _, s := range career.Education {
+				timeline = append(timeline, timelineEntry{"education", s.Name, s.Program, s.Start, s.End, "", nil})
+			}
+		}
+		sort.SliceStable(timeline, func(i, j int) bool { return timeline[i].Start < timeline[j].Start })
+		result = timeline
+	case "get_skills":
+		type skillExperience struct {
+			skill
+			Years float64 `json:"years"`
+		}
+		skills := []skillExperience{}
+		for _, s := range career.Skills {
+			if (args.Name == "" || strings.EqualFold(s.Name, args.Name)) &&
+				(args.Category == "" || strings.EqualFold(s.Category, args.Category)) {
+				skills = append(skills, skillExperience{s, yearsSince(s.Since, now)})
+			}
+		}
+		result = skills
+	case "get_projects":
+		projects := []project{}
+		for _, p := range career.Projects {
+			for _, s := range p.Skills {
+				if args.Skill == "" || strings.EqualFold(s, args.Skill) {
+					projects = append(projects, p)
+					break
+				}
+			}
+			if args.Skill == "" && len(p.Skills) == 0 {
+				projects = append(projects, p)
+			}
+		}
+		sort.SliceStable(projects, func(i, j int) bool { return projects[i].Year > projects[j].Year })
+		result = projects
+	case "get_contact":
+		result = career.Contact
+	default:
+		result = map[string]string{"error": fmt.Sprintf("unknown tool '%s'", call.Function.Name)}
+	}
+	return string(unwrap(json.Marshal(result)))
+}
+
+// askWithTools sends a request with the career tools, runs the tool calls the model makes and sends their results
+// back, until the model answers (or runs out of rounds, after which it has to answer without the tools). It returns
+// the final response, with the usage of all the rounds.
+func (career careerData) askWithTools(ctx context.Context, client provider,
+	request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+
+	var usage openai.Usage
+	for round := 0; ; round++ {
+		if round < maxToolRounds {
+			request.Tools = careerTools
+		} else {
+			request.Tools = nil
+		}
+		resp, err := client.CreateChatCompletion(ctx, request)
+		if err != nil {
+			return resp, err
+		}
+		usage.PromptTokens += resp.Usage.PromptTokens
+		usage.CompletionTokens += resp.Usage.CompletionTokens
+		usage.TotalTokens += resp.Usage.TotalTokens
+		resp.Usage = usage
+		if len(resp.Choices) == 0 || len(resp.Choices[0].Message.ToolCalls) == 0 || request.Tools == nil {
+			return resp, nil
+		}
+
+		request.Messages = append(request.Messages, resp.Choices[0].Message)
+		for _, call := range resp.Choices[0].Message.ToolCalls {
+			log.Debugf("Calling tool %s(%s)", call.Function.Name, call.Function.Arguments)
+			request.Messages = append(request.Messages, openai.ChatCompletionMessage{
+				Role:       openai.ChatMessageRoleTool,
+				ToolCallID: call.ID,
+				Name:       call.Function.Name,
+				Content:    career.callTool(call, time.Now()),
+			})
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+var testCareer = careerData{
+	Employers: []employer{
+		{Name: "Example Corp", Role: "Software Engineer", Start: "2022-01"},
+		{Name: "Acme", Role: "Intern", Start: "2021-10", End: "2021-12"},
+	},
+	Education: []school{{Name: "Grand Circus", Program: "Java Bootcamp", Start: "2021-06", End: "2021-09"}},
+	Skills: []skill{
+		{Name: "Go", Category: "language", Since: "2021-10"},
+		{Name: "PostgreSQL", Category: "database", Since: "2022-01"},
+	},
+	Projects: []project{
+		{Name: "old", Year: 2021, Skills: []string{"Java"}},
+		{Name: "portfolio-chatbot", Year: 2023, Skills: []string{"Go", "PostgreSQL"}},
+	},
+	Contact: map[string]string{"website": "https://krischerven.info"},
+}
+
+func toolCall(name, arguments string) openai.ToolCall {
+	return openai.ToolCall{ID: "call_" + name, Type: openai.ToolTypeFunction,
+		Function: openai.FunctionCall{Name: name, Arguments: arguments}}
+}
+
+func TestCallTool(t *testing.T) {
+
+	now := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	var timeline []timelineEntry
+	testAssert(t, json.Unmarshal([]byte(testCareer.callTool(toolCall("get_timeline", `{}`), now)), &timeline) == nil)
+	testAssert(t, len(timeline) == 3 && timeline[0].Name == "Grand Circus" && timeline[1].Name == "Acme" &&
+		timeline[2].Name == "Example Corp" && timeline[2].End == "")
+	testAssert(t, strings.Count(testCareer.callTool(toolCall("get_timeline", `{"kind": "work"}`), now), "kind") == 2)
+
+	testAssert(t, testCareer.callTool(toolCall("get_skills", `{"name": "go"}`), now) ==
+		`[{"name":"Go","category":"language","since":"2021-10","years":2.5}]`)
+	testAssert(t, testCareer.callTool(toolCall("get_skills", `{"category": "design"}`), now) == `[]`)
+
+	testAssert(t, strings.HasPrefix(testCareer.callTool(toolCall("get_projects", ""), now),
+		`[{"name":"portfolio-chatbot"`))
+	testAssert(t, !strings.Contains(testCareer.callTool(toolCall("get_projects", `{"skill": "Go"}`), now), "old"))
+	testAssert(t, testCareer.callTool(toolCall("get_contact", ""), now) == `{"website":"https://krischerven.info"}`)
+
+	testAssert(t, strings.Contains(testCareer.callTool(toolCall("get_salary", ""), now), "error"))
+	testAssert(t, strings.Contains(testCareer.callTool(toolCall("get_skills", "{"), now), "invalid arguments"))
+}
+
+// toolProvider calls a tool for as long as it's offered one, up to calls times, and then answers with what it got
+type toolProvider struct {
+	calls    int
+	requests int
+}
+
+func (p *toolProvider) CreateChatCompletion(_ context.Context,
+	request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+
+	p.requests++
+	message := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant}
+	if len(request.Tools) > 0 && p.calls > 0 {
+		p.calls--
+		message.ToolCalls = []openai.ToolCall{toolCall("get_contact", "")}
+	} else {
+		message.Content = "Answer: " + request.Messages[len(request.Messages)-1].Content
+	}
+	return openai.ChatCompletionResponse{
+		Choices: []openai.ChatCompletionChoice{{Message: message}},
+		Usage:   openai.Usage{PromptTokens: 10, CompletionTokens: 1},
+	}, nil
+}
+
+func TestAskWithTools(t *testing.T) {
+
+	ctx := context.Background()
+	request := openai.ChatCompletionRequest{Model: "model", Messages: []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleUser, Content: "How can I contact Kris?"}}}
+
+	client := &toolProvider{calls: 1}
+	resp, err := testCareer.askWithTools(ctx, client, request)
+	testAssert(t, err == nil && client.requests == 2)
+	testAssert(t, resp.Choices[0].Message.Content == `Answer: {"website":"https://krischerven.info"}`)
+	testAssert(t, resp.Usage.PromptTokens == 20 && resp.Usage.CompletionTokens == 2)
+
+	// A model that keeps calling tools has to answer without them eventually
+	client = &toolProvider{calls: 100}
+	resp, err = testCareer.askWithTools(ctx, client, request)
+	testAssert(t, err == nil && client.requests == maxToolRounds+1 && resp.Choices[0].Message.Content != "")
+
+	chunks := testCareer.chunks()
+	testAssert(t, len(chunks) == 8 && chunks[0].text == "Software Engineer at Example Corp (2022-01 to now)")
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"math"
+	"regexp"
+	"strings"
+)
+
+type topic struct {
+	Name     string   `yaml:"name"`
+	Keywords []string `yaml:"keywords"`
+	Examples []string `yaml:"examples"`
+}
+
+type topicGuardConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// keywords: a question is on-topic if it mentions any topic's keywords
+	// tfidf: a question is on-topic if it's most similar to a topic's examples (rather than to off-topic-examples),
+	// and at least min-similarity similar
+	// model: classifier-model is asked whether the question is about any of the topics
+	Classifier       string   `yaml:"classifier"`
+	Topics           []topic  `yaml:"topics"`
+	OffTopicExamples []string `yaml:"off-topic-examples"`
+	MinSimilarity    float64  `yaml:"min-similarity"`
+	ClassifierModel  string   `yaml:"classifier-model"`
+	// Small talk (e.g., "hi" or "thanks") is always allowed
+	SmallTalk  []string `yaml:"small-talk"`
+	Deflection string   `yaml:"deflection"`
+}
+
+var topicClassifiers = map[string]bool{"keywords": true, "tfidf": true, "model": true}
+
+const offTopicLabel = "off-topic"
+
+// A few very common words that would otherwise make every question look a bit like every other one
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "at": true, "be": true, "can": true, "did": true, "do": true,
+	"does": true, "for": true, "how": true, "i": true, "in": true, "is": true, "it": true, "of": true,
+	"on": true, "or": true, "the": true, "to": true, "was": true, "what": true, "where": true, "who": true,
+	"why": true, "with": true,
+}
+
+// Redaction placeholders (see redact.go) aren't words the visitor wrote
+var placeholderPattern = regexp.MustCompile(`\[[A-Z_]+_\d+\]`)
+
+func topicWords(text string) []string {
+	var words []string
+	for _, word := range wordPattern.FindAllString(strings.ToLower(placeholderPattern.ReplaceAllString(text, " ")), -1) {
+		word = strings.TrimSuffix(strings.Trim(word, "'"), "'s")
+		if word != "" && !stopWords[word] {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// tfidfModel is a nearest-centroid classifier over TF-IDF vectors
+type tfidfModel struct {
+	idf       map[string]float64
+	unseenIdf float64
+	centroids map[string]map[string]float64
+}
+
+// trainTFIDF trains a tfidfModel from example texts, by label
+func trainTFIDF(examples map[string][]string) tfidfModel {
+	documents := 0
+	df := make(map[string]int)
+	for _, texts := range examples {
+		for _, text := range texts {
+			documents++
+			seen := make(map[string]bool)
+			for _, word := range topicWords(text) {
+				if !seen[word] {
+					seen[word] = true
+					df[word]++
+				}
+			}
+		}
+	}
+	// Smoothed, so that words in every document still count a little
+	m := tfidfModel{make(map[string]float64), math.Log(float64(1+documents)) + 1, make(map[string]map[string]float64)}
+	for word, n := range df {
+		m.idf[word] = math.Log(float64(1+documents)/float64(1+n)) + 1
+	}
+	for label, texts := range examples {
+		centroid := make(map[string]float64)
+		for _, text := range texts {
+			for word, weight := range m.vector(text) {
+				centroid[word] += weight / float64(len(texts))
+			}
+		}
+		m.centroids[label] = centroid
+	}
+	return m
+}
+
+// vector is the normalized TF-IDF vector of a text. Words that weren't in any example count as the rarest words, so
+// that a single familiar word in a long question doesn't make it look like the examples.
+func (m tfidfModel) vector(text string) map[string]float64 {
+	v := make(map[string]float64)
+	for _, word := range topicWords(text) {
+		if idf, ok := m.idf[word]; ok {
+			v[word] += idf
+		} else {
+			v[word] += m.unseenIdf
+		}
+	}
+	norm := 0.0
+	for _, weight := range v {
+		norm += weight * weight
+	}
+	for word := range v {
+		v[word] /= math.Sqrt(norm)
+	}
+	return v
+}
+
+func cosine(a, b map[string]float64) float64 {
+	dot, normB := 0.0, 0.0
+	for word, weight := range b {
+		dot += a[word] * weight
+		normB += weight * weight
+	}
+	if normB == 0 {
+		return 0
+	}
+	// a is already normalized
+	return dot / math.Sqrt(normB)
+}
+
+// classify returns the label whose examples are most similar to text, and how similar they are
+func (m tfidfModel) classify(text string) (string, float64) {
+	v := m.vector(text)
+	best, bestSimilarity := offTopicLabel, 0.0
+	for label, centroid := range m.centroids {
+		if similarity := cosine(v, centroid); similarity > bestSimilarity {
+			best, bestSimilarity = label, similarity
+		}
+	}
+	return best, bestSimilarity
+}
+
+var smallTalkTrim = regexp.MustCompile(`[^\p{L}\p{N}' ]+`)
+
+func (config topicGuardConfig) isSmallTalk(question string) bool {
+	normalized := strings.Join(strings.Fields(smallTalkTrim.ReplaceAllString(strings.ToLower(question), " ")), " ")
+	for _, phrase := range config.SmallTalk {
+		if normalized == strings.ToLower(phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+func (config topicGuardConfig) matchesKeywords(question string) (string, bool) {
+	words := make(map[string]bool)
+	for _, word := range topicWords(question) {
+		words[word] = true
+	}
+	lower := " " + strings.Join(strings.Fields(strings.ToLower(question)), " ") + " "
+	for _, t := range config.Topics {
+		for _, keyword := range t.Keywords {
+			keyword = strings.ToLower(keyword)
+			// Multi-word keywords are matched as phrases
+			if words[keyword] || (strings.Contains(keyword, " ") && strings.Contains(lower, " "+keyword)) {
+				return t.Name, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (config topicGuardConfig) topicPrompt(question string) string {
+	var names []string
+	for _, t := range config.Topics {
+		names = append(names, t.Name)
+	}
+	return "You are a filter for a chatbot that answers questions about a software engineer named Kris Cherven. " +
+		"Is the following question about Kris and one of these topics: " + strings.Join(names, ", ") +
+		"? Answer with only YES or NO.\n\nQuestion: " + question
+}
+
+// offTopic decides whether a question is off-topic, with the configured classifier. It returns the reason, for the
+// guard_incidents table. A model classifier that fails (or a missing provider) lets the question through.
+func (config topicGuardConfig) offTopic(ctx context.Context, client provider, question string) (string, bool) {
+	if !config.Enabled || config.isSmallTalk(question) {
+		return "", false
+	}
+	switch config.Classifier {
+	case "keywords":
+		if _, ok := config.matchesKeywords(question); !ok {
+			return "keywords: no topic keywords", true
+		}
+	case "tfidf":
+		examples := map[string][]string{offTopicLabel: config.OffTopicExamples}
+		for _, t := range config.Topics {
+			examples[t.Name] = t.Examples
+		}
+		label, similarity := trainTFIDF(examples).classify(question)
+		if label == offTopicLabel || similarity < config.MinSimilarity {
+			return "tfidf: closest to " + label, true
+		}
+	case "model":
+		if client == nil {
+			break
+		}
+		yes, err := askYesNo(ctx, client, config.ClassifierModel, config.topicPrompt(question))
+		if err != nil {
+			log.Warnf("Topic classifier failed: %v", err)
+		} else if !yes {
+			return "model: " + config.ClassifierModel, true
+		}
+	}
+	return "", false
+}